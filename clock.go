@@ -0,0 +1,34 @@
+package zap2slog
+
+import "time"
+
+// Clock provides the current time.  Set it in ZapHandlerOptions or SlogCoreOptions to
+// fill in the time of records and entries which have none, or to control that time in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is a Clock which returns time.Now().
+var SystemClock Clock = systemClock{}
+
+// defaultTime returns t, or the clock's time if t is zero and the clock is set.
+func defaultTime(clock Clock, t time.Time) time.Time {
+	if t.IsZero() && clock != nil {
+		return clock.Now()
+	}
+	return t
+}
+
+// orSystemClock returns clock, or SystemClock if clock is nil.
+func orSystemClock(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+	return clock
+}
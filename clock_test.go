@@ -0,0 +1,85 @@
+package zap2slog
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeClock is a Clock which returns a fixed time, until it's advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestSystemClock(t *testing.T) {
+	before := time.Now()
+	now := SystemClock.Now()
+	assert.False(t, now.Before(before))
+	assert.False(t, time.Now().Before(now))
+}
+
+func TestZapHandler_Clock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	core, logs := observer.New(zapcore.DebugLevel)
+	h := NewZapHandler(core, &ZapHandlerOptions{Clock: clock})
+
+	// zero times are defaulted from the clock
+	require.NoError(t, h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "first", 0)))
+	clock.Advance(time.Second)
+	require.NoError(t, h.WithGroup("g").Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "second", 0)))
+
+	// other times are kept
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, h.Handle(context.Background(), slog.NewRecord(ts, slog.LevelInfo, "third", 0)))
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), entries[0].Time)
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC), entries[1].Time)
+	assert.Equal(t, ts, entries[2].Time)
+
+	// without a clock, the zero time is passed through
+	require.NoError(t, NewZapHandler(core, nil).Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "zero", 0)))
+	assert.True(t, logs.TakeAll()[0].Time.IsZero())
+}
+
+func TestSlogCore_Clock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	var buf strings.Builder
+	core := NewSlogCore(slog.NewTextHandler(&buf, nil), &SlogCoreOptions{Clock: clock})
+
+	require.NoError(t, core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "first"}, nil))
+	clock.Advance(time.Second)
+	require.NoError(t, core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "second"}, nil))
+	require.NoError(t, core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "third", Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}, nil))
+
+	assert.Equal(t, "time=2024-01-02T03:04:05.000Z level=INFO msg=first\n"+
+		"time=2024-01-02T03:04:06.000Z level=INFO msg=second\n"+
+		"time=2020-01-01T00:00:00.000Z level=INFO msg=third\n", buf.String())
+
+	// without a clock, the zero time is passed through, and the handler omits it
+	buf.Reset()
+	require.NoError(t, NewSlogCore(slog.NewTextHandler(&buf, nil), nil).Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "zero"}, nil))
+	assert.Equal(t, "level=INFO msg=zero\n", buf.String())
+}
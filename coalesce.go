@@ -0,0 +1,155 @@
+package zap2slog
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RepeatedKey is the key of the attribute added to coalesced log entries, containing the
+// number of identical entries which were suppressed.
+const RepeatedKey = "repeated"
+
+// entryWriter writes a repeated zap entry and its final fields.
+type entryWriter interface {
+	writeRepeated(e zapcore.Entry, fields []zapcore.Field) error
+}
+
+// coalescer suppresses consecutive identical log entries.  The first entry is written
+// immediately.  Identical entries which follow it within the window are counted but not
+// written.  When a different entry arrives, the window expires, or the coalescer is flushed,
+// the last suppressed entry is written with a RepeatedKey field containing the count.
+//
+// Entries are identical if they have the same level, logger name, message, and fields.  The time
+// and caller are ignored.  Only the last entry is buffered, and a single timer expires its window.
+// Repeated entries are written after the lock is released, so a slow writer doesn't block
+// other goroutines from logging.
+type coalescer struct {
+	window time.Duration
+	// now returns the current time, for checking the deadline.  Tests replace it.
+	now func() time.Time
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	deadline time.Time
+	w        entryWriter
+	last     zapcore.Entry
+	fields   []zapcore.Field
+	repeated int
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	if window <= 0 {
+		return nil
+	}
+	return &coalescer{window: window, now: time.Now}
+}
+
+// repeatedEntry is a suppressed entry, with the RepeatedKey field, which is waiting to be written.
+type repeatedEntry struct {
+	w      entryWriter
+	e      zapcore.Entry
+	fields []zapcore.Field
+}
+
+// write writes the entry.  r may be nil, in which case there is nothing to write.
+func (r *repeatedEntry) write() error {
+	if r == nil {
+		return nil
+	}
+	return r.w.writeRepeated(r.e, r.fields)
+}
+
+// suppress returns true if the entry is identical to the last entry, and should not be written.
+// Otherwise, the caller should write the entry.  Either way, the caller must first write the
+// returned pending repeated entry, if any.
+func (c *coalescer) suppress(w entryWriter, e zapcore.Entry, fields []zapcore.Field) (bool, *repeatedEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.w != nil && sameEntry(c.last, e) && sameFields(c.fields, fields) {
+		c.repeated++
+		c.last = e
+		return true, nil
+	}
+
+	pending := c.takeLocked()
+	c.w, c.last, c.fields = w, e, fields
+	c.deadline = c.now().Add(c.window)
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.window, c.expire)
+	} else {
+		c.timer.Reset(c.window)
+	}
+	return false, pending
+}
+
+// expire ends the current window, if its deadline has passed.  The timer may fire for a
+// window which was since replaced, if it was reset while expire was waiting for the lock.
+func (c *coalescer) expire() {
+	c.mu.Lock()
+	if c.now().Before(c.deadline) {
+		c.mu.Unlock()
+		return
+	}
+	pending := c.takeLocked()
+	c.w, c.last, c.fields = nil, zapcore.Entry{}, nil
+	c.mu.Unlock()
+
+	_ = pending.write()
+}
+
+// flush writes the pending repeated entry, if any.
+func (c *coalescer) flush() error {
+	c.mu.Lock()
+	pending := c.takeLocked()
+	c.mu.Unlock()
+
+	return pending.write()
+}
+
+// takeLocked returns the pending repeated entry, if any, and resets the count.
+func (c *coalescer) takeLocked() *repeatedEntry {
+	if c.repeated == 0 {
+		return nil
+	}
+	repeated := c.repeated
+	c.repeated = 0
+
+	fields := make([]zapcore.Field, len(c.fields), len(c.fields)+1)
+	copy(fields, c.fields)
+	return &repeatedEntry{w: c.w, e: c.last, fields: append(fields, zap.Int(RepeatedKey, repeated))}
+}
+
+func sameEntry(a, b zapcore.Entry) bool {
+	return a.Level == b.Level &&
+		a.LoggerName == b.LoggerName &&
+		a.Message == b.Message &&
+		a.Stack == b.Stack
+}
+
+func sameFields(a, b []zapcore.Field) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameField(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameField is like zapcore.Field.Equals, which compares some types of fields with ==.  That panics
+// if the field's Interface holds an uncomparable value, like the net.IP in a zap.Stringer field, so
+// the values are compared with reflect.DeepEqual instead.
+func sameField(a, b zapcore.Field) bool {
+	return a.Key == b.Key &&
+		a.Type == b.Type &&
+		a.Integer == b.Integer &&
+		a.String == b.String &&
+		reflect.DeepEqual(a.Interface, b.Interface)
+}
@@ -0,0 +1,226 @@
+package zap2slog
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// syncBuilder is a strings.Builder which is safe for concurrent use.
+type syncBuilder struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (s *syncBuilder) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuilder) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestSlogCore_Coalesce(t *testing.T) {
+	t.Run("burst", func(t *testing.T) {
+		var buf syncBuilder
+		h := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+		l := NewZapLogger(h, &SlogCoreOptions{CoalesceWindow: time.Hour}).With(zap.String("env", "prod"))
+
+		for i := 0; i < 5; i++ {
+			l.Info("hello", zap.String("user", "alice"))
+		}
+		l.Info("hello", zap.String("user", "bob"))
+		l.Info("hello", zap.String("user", "bob"))
+		l.Warn("hello", zap.String("user", "bob"))
+		l.Warn("hello", zap.String("user", "bob"))
+		l.Warn("hello", zap.String("user", "bob"))
+
+		require.Equal(t, strings.Join([]string{
+			`level=INFO msg=hello env=prod user=alice`,
+			`level=INFO msg=hello env=prod user=alice repeated=4`,
+			`level=INFO msg=hello env=prod user=bob`,
+			`level=INFO msg=hello env=prod user=bob repeated=1`,
+			`level=WARN msg=hello env=prod user=bob`,
+		}, "\n")+"\n", buf.String())
+
+		require.NoError(t, l.Sync())
+		require.True(t, strings.HasSuffix(buf.String(), "level=WARN msg=hello env=prod user=bob repeated=2\n"))
+
+		// syncing again doesn't write anything
+		before := buf.String()
+		require.NoError(t, l.Sync())
+		require.Equal(t, before, buf.String())
+	})
+
+	t.Run("window expires", func(t *testing.T) {
+		var buf syncBuilder
+		core := NewSlogCore(slog.NewTextHandler(&buf, nil), &SlogCoreOptions{CoalesceWindow: time.Hour})
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		core.coalescer.now = clock.Now
+		l := zap.New(core)
+
+		l.Info("hello")
+		l.Info("hello")
+		l.Info("hello")
+		require.Equal(t, 1, strings.Count(buf.String(), "\n"))
+
+		// a timer which fires before the deadline, because it was reset, is ignored
+		core.coalescer.expire()
+		require.Equal(t, 1, strings.Count(buf.String(), "\n"))
+
+		clock.Advance(time.Hour)
+		core.coalescer.expire()
+		require.Equal(t, 2, strings.Count(buf.String(), "\n"))
+		require.Contains(t, buf.String(), "msg=hello repeated=2\n")
+
+		// after the window expires, the next identical entry is written
+		l.Info("hello")
+		require.Equal(t, 3, strings.Count(buf.String(), "\n"))
+	})
+
+	t.Run("one timer", func(t *testing.T) {
+		core := NewSlogCore(slog.NewTextHandler(io.Discard, nil), &SlogCoreOptions{CoalesceWindow: time.Hour})
+		l := zap.New(core)
+		l.Info("first")
+		timer := core.coalescer.timer
+		require.NotNil(t, timer)
+		for i := 0; i < 10; i++ {
+			l.Info("distinct", zap.Int("i", i))
+		}
+		require.Same(t, timer, core.coalescer.timer)
+	})
+
+	t.Run("repeated entries are written like others", func(t *testing.T) {
+		var written []string
+		var syncs int
+		l := NewZapLogger(slog.NewTextHandler(io.Discard, nil), &SlogCoreOptions{
+			CoalesceWindow: time.Hour,
+			FlushOnLevel:   slog.LevelError,
+			SyncFunc: func() error {
+				syncs++
+				return nil
+			},
+			OnWrite: func(e zapcore.Entry) {
+				written = append(written, e.Message)
+			},
+		})
+		l.Error("boom")
+		l.Error("boom")
+		require.Equal(t, 1, syncs)
+		require.NoError(t, l.Sync())
+		// flushing the repeated entry syncs for FlushOnLevel, then Sync syncs again
+		require.Equal(t, 3, syncs)
+		// the suppressed entry isn't counted, but the repeated entry is
+		require.Equal(t, []string{"boom", "boom"}, written)
+	})
+
+	t.Run("written without the lock", func(t *testing.T) {
+		// a handler which syncs the core while handling the repeated entry would deadlock if
+		// the coalescer held its lock while writing
+		var l *zap.Logger
+		h := &syncingHandler{Handler: slog.NewTextHandler(io.Discard, nil), sync: func() { _ = l.Sync() }}
+		l = NewZapLogger(h, &SlogCoreOptions{CoalesceWindow: time.Hour})
+		l.Info("hello")
+		l.Info("hello")
+		l.Info("bye")
+		require.Equal(t, 1, h.synced)
+	})
+}
+
+// syncingHandler calls sync when it handles a record with the RepeatedKey attribute.
+type syncingHandler struct {
+	slog.Handler
+	sync   func()
+	synced int
+}
+
+func (h *syncingHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == RepeatedKey {
+			h.synced++
+			h.sync()
+		}
+		return true
+	})
+	return h.Handler.Handle(ctx, r)
+}
+
+func TestZapHandler_Coalesce(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := NewLogger(core, &ZapHandlerOptions{CoalesceWindow: time.Hour}).With("env", "prod")
+
+	for i := 0; i < 5; i++ {
+		l.Info("hello", "user", "alice")
+	}
+	l.WithGroup("req").Info("hello", "user", "alice")
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, []zapcore.Field{zap.String("env", "prod"), zap.String("user", "alice")}, entries[0].Context)
+	assert.Equal(t, []zapcore.Field{zap.String("env", "prod"), zap.String("user", "alice"), zap.Int(RepeatedKey, 4)}, entries[1].Context)
+	assert.Equal(t, []zapcore.Field{zap.String("env", "prod"), zap.Any("req", []zapcore.Field{zap.String("user", "alice")})}, entries[2].Context)
+	for _, e := range entries {
+		assert.Equal(t, "hello", e.Message)
+	}
+}
+
+func TestZapHandler_CoalesceWithOptions(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	// enabling coalescing
+	h := NewZapHandler(core, nil).WithOptions(func(o *ZapHandlerOptions) { o.CoalesceWindow = time.Hour })
+	for i := 0; i < 5; i++ {
+		slog.New(h).Info("hello")
+	}
+	require.NoError(t, h.Sync())
+	entries := logs.TakeAll()
+	require.Len(t, entries, 2)
+	assert.Equal(t, []zapcore.Field{zap.Int(RepeatedKey, 4)}, entries[1].Context)
+
+	// disabling coalescing
+	h = h.WithOptions(func(o *ZapHandlerOptions) { o.CoalesceWindow = 0 })
+	for i := 0; i < 5; i++ {
+		slog.New(h).Info("hello")
+	}
+	assert.Len(t, logs.TakeAll(), 5)
+}
+
+func TestZapHandler_CoalesceUncomparableFields(t *testing.T) {
+	// net.IP attrs are converted to zap.Stringer fields, which zapcore.Field.Equals can't compare
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := NewLogger(core, &ZapHandlerOptions{CoalesceWindow: time.Hour})
+
+	ip := net.ParseIP("10.0.0.1")
+	l.Info("hello", "ip", ip, "mac", net.HardwareAddr{1, 2, 3, 4, 5, 6})
+	l.Info("hello", "ip", net.ParseIP("10.0.0.1"), "mac", net.HardwareAddr{1, 2, 3, 4, 5, 6})
+	l.Info("hello", "ip", net.ParseIP("10.0.0.2"), "mac", net.HardwareAddr{1, 2, 3, 4, 5, 6})
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3)
+	assert.Equal(t, "10.0.0.1", entries[0].ContextMap()["ip"])
+	assert.Equal(t, map[string]any{"ip": "10.0.0.1", "mac": "01:02:03:04:05:06", RepeatedKey: int64(1)}, entries[1].ContextMap())
+	assert.Equal(t, "10.0.0.2", entries[2].ContextMap()["ip"])
+}
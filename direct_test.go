@@ -0,0 +1,79 @@
+package zap2slog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNewZapHandlerDirect(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	newRecord := func(level slog.Level) slog.Record {
+		r := slog.NewRecord(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), level, "hello", pc)
+		r.AddAttrs(slog.String("user", "alice"), slog.Group("req", slog.Int("status", 200)), slog.String("logger", "http"))
+		return r
+	}
+	opts := &ZapHandlerOptions{AddSource: true, LoggerNameKey: "logger", ShortSourceKey: "caller"}
+	log := func(h slog.Handler) {
+		h = h.WithAttrs([]slog.Attr{slog.String("service", "api")}).WithGroup("g")
+		for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelError} {
+			require.NoError(t, h.Handle(context.Background(), newRecord(level)))
+		}
+	}
+
+	var viaCore, direct bytes.Buffer
+	log(NewZapHandler(zapcore.NewCore(zapcore.NewJSONEncoder(DefaultEncoderConfig()), zapcore.AddSync(&viaCore), zapcore.InfoLevel), opts))
+	log(NewZapHandlerDirect(zapcore.NewJSONEncoder(DefaultEncoderConfig()), zapcore.AddSync(&direct), zapcore.InfoLevel, opts))
+
+	assert.Equal(t, 2, bytes.Count(direct.Bytes(), []byte("\n")))
+	assert.Equal(t, viaCore.String(), direct.String())
+
+	h := NewZapHandlerDirect(zapcore.NewJSONEncoder(DefaultEncoderConfig()), zapcore.AddSync(&direct), zapcore.InfoLevel, nil)
+	assert.False(t, h.Enabled(context.Background(), slog.LevelDebug))
+	assert.True(t, h.Enabled(context.Background(), slog.LevelInfo))
+	require.NoError(t, h.Sync())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestNewZapHandlerDirect_WriteError(t *testing.T) {
+	h := NewZapHandlerDirect(zapcore.NewJSONEncoder(DefaultEncoderConfig()), zapcore.AddSync(failingWriter{}), zapcore.InfoLevel, nil)
+	err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "hello", 0))
+	assert.EqualError(t, err, "disk full")
+}
+
+func BenchmarkZapHandlerDirect(b *testing.B) {
+	enc := zapcore.NewJSONEncoder(DefaultEncoderConfig())
+	ws := zapcore.AddSync(io.Discard)
+	handlers := map[string]*ZapHandler{
+		"core":   NewZapHandler(zapcore.NewCore(enc, ws, zapcore.InfoLevel), nil),
+		"direct": NewZapHandlerDirect(enc, ws, zapcore.InfoLevel, nil),
+	}
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "benchmark", 0)
+	r.AddAttrs(
+		slog.String("method", "POST"),
+		slog.Int("status", 200),
+		slog.String("id", "123"),
+		slog.String("name", "alice"),
+	)
+	for _, name := range []string{"core", "direct"} {
+		h := handlers[name].WithAttrs([]slog.Attr{slog.String("service", "api")})
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = h.Handle(context.Background(), r)
+			}
+		})
+	}
+}
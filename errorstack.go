@@ -0,0 +1,90 @@
+package zap2slog
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultErrorStackKey is the key of the stack frames added by the ExtractErrorStacks
+// options, if ErrorStackKey isn't set.
+const DefaultErrorStackKey = "stack"
+
+// errorStack returns the program counters of the stack trace recorded by err, or the first error
+// it wraps which has one.  Errors record stack traces by implementing a StackTrace method
+// returning a slice of program counters, like StackTrace() []uintptr.  The element type may be a
+// named uintptr type, so errors from github.com/pkg/errors, whose StackTrace method returns
+// errors.StackTrace, a []errors.Frame, are supported too.
+func errorStack(err error) []uintptr {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if st, ok := err.(interface{ StackTrace() []uintptr }); ok {
+			return st.StackTrace()
+		}
+		m := reflect.ValueOf(err).MethodByName("StackTrace")
+		if !m.IsValid() {
+			continue
+		}
+		t := m.Type()
+		if t.NumIn() != 0 || t.NumOut() != 1 || t.Out(0).Kind() != reflect.Slice || t.Out(0).Elem().Kind() != reflect.Uintptr {
+			continue
+		}
+		frames := m.Call(nil)[0]
+		pcs := make([]uintptr, frames.Len())
+		for i := range pcs {
+			pcs[i] = uintptr(frames.Index(i).Uint())
+		}
+		return pcs
+	}
+	return nil
+}
+
+// errorWithStack marshals an error as an object with the error's message and its stack frames.
+type errorWithStack struct {
+	err      error
+	stackKey string
+	pcs      []uintptr
+}
+
+// newErrorWithStack returns an errorWithStack for err, or false if err doesn't have a stack trace.
+func newErrorWithStack(err error, stackKey string) (errorWithStack, bool) {
+	pcs := errorStack(err)
+	if len(pcs) == 0 {
+		return errorWithStack{}, false
+	}
+	if stackKey == "" {
+		stackKey = DefaultErrorStackKey
+	}
+	return errorWithStack{err: err, stackKey: stackKey, pcs: pcs}, true
+}
+
+func (e errorWithStack) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("msg", e.err.Error())
+	return enc.AddArray(e.stackKey, stackFrames(e.pcs))
+}
+
+// stackFrames marshals program counters as an array of frames, with the function, file, and line
+// of each frame.
+type stackFrames []uintptr
+
+func (pcs stackFrames) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		if frame.PC != 0 {
+			err := enc.AppendObject(zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("function", frame.Function)
+				enc.AddString("file", frame.File)
+				enc.AddInt("line", frame.Line)
+				return nil
+			}))
+			if err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
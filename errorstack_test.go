@@ -0,0 +1,118 @@
+package zap2slog
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"testing"
+
+	"github.com/ansel1/zap2slog/zap2slogtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// pkgErrorsFrame and pkgErrorsStackTrace mimic the types returned by github.com/pkg/errors'
+// StackTrace method.
+type pkgErrorsFrame uintptr
+
+type pkgErrorsStackTrace []pkgErrorsFrame
+
+type pkgErrorsError struct {
+	msg   string
+	stack []uintptr
+}
+
+func newPkgErrorsError(msg string) *pkgErrorsError {
+	var pcs [32]uintptr
+	n := runtime.Callers(2, pcs[:])
+	return &pkgErrorsError{msg: msg, stack: pcs[:n]}
+}
+
+func (e *pkgErrorsError) Error() string { return e.msg }
+
+func (e *pkgErrorsError) StackTrace() pkgErrorsStackTrace {
+	st := make(pkgErrorsStackTrace, len(e.stack))
+	for i, pc := range e.stack {
+		st[i] = pkgErrorsFrame(pc)
+	}
+	return st
+}
+
+type uintptrStackError []uintptr
+
+func (uintptrStackError) Error() string           { return "boom" }
+func (e uintptrStackError) StackTrace() []uintptr { return e }
+
+func TestErrorStack(t *testing.T) {
+	err := newPkgErrorsError("boom")
+	assert.Equal(t, err.stack, errorStack(err))
+	assert.Equal(t, err.stack, errorStack(fmt.Errorf("wrapped: %w", err)))
+	assert.Equal(t, []uintptr{1, 2}, errorStack(uintptrStackError{1, 2}))
+	assert.Nil(t, errorStack(errors.New("boom")))
+	assert.Nil(t, errorStack(nil))
+}
+
+func TestSlogCore_ExtractErrorStacks(t *testing.T) {
+	err := newPkgErrorsError("boom")
+	h := zap2slogtest.NewRecordingHandler(nil)
+	NewZapLogger(h, &SlogCoreOptions{ExtractErrorStacks: true, ErrorStackKey: "frames"}).
+		Info("hi", zap.Error(err), zap.NamedError("plain", errors.New("bang")))
+
+	r, ok := h.Last()
+	require.True(t, ok)
+	msg, ok := zap2slogtest.FindAttr(r, "error", "msg")
+	require.True(t, ok)
+	assert.Equal(t, "boom", msg.Value.String())
+
+	frames, ok := zap2slogtest.FindAttr(r, "error", "frames")
+	require.True(t, ok)
+	require.IsType(t, []any{}, frames.Value.Any())
+	first := frames.Value.Any().([]any)[0].(ArrayObject)
+	require.Len(t, first, 3)
+	assert.Equal(t, slog.String("function", "github.com/ansel1/zap2slog.TestSlogCore_ExtractErrorStacks"), first[0])
+	assert.Equal(t, "file", first[1].Key)
+	assert.Contains(t, first[1].Value.String(), "errorstack_test.go")
+	assert.Equal(t, "line", first[2].Key)
+
+	// errors without stacks are just the message
+	plain, ok := zap2slogtest.FindAttr(r, "plain")
+	require.True(t, ok)
+	assert.Equal(t, "bang", plain.Value.String())
+
+	// disabled by default
+	h.Reset()
+	NewZapLogger(h, nil).Info("hi", zap.Error(err))
+	r, _ = h.Last()
+	a, ok := zap2slogtest.FindAttr(r, "error")
+	require.True(t, ok)
+	assert.Equal(t, "boom", a.Value.String())
+}
+
+func TestZapHandler_ExtractErrorStacks(t *testing.T) {
+	err := newPkgErrorsError("boom")
+	core, logs := observer.New(zapcore.InfoLevel)
+	slog.New(NewZapHandler(core, &ZapHandlerOptions{ExtractErrorStacks: true})).
+		Info("hi", "err", err, "plain", errors.New("bang"))
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	ctx := entries[0].ContextMap()
+	assert.Equal(t, "bang", ctx["plain"])
+
+	obj := ctx["err"].(map[string]any)
+	assert.Equal(t, "boom", obj["msg"])
+	frames := obj[DefaultErrorStackKey].([]any)
+	require.NotEmpty(t, frames)
+	first := frames[0].(map[string]any)
+	assert.Equal(t, "github.com/ansel1/zap2slog.TestZapHandler_ExtractErrorStacks", first["function"])
+	assert.Contains(t, first["file"], "errorstack_test.go")
+	assert.NotZero(t, first["line"])
+
+	// disabled by default
+	slog.New(NewZapHandler(core, nil)).Info("hi", "err", err)
+	assert.Equal(t, "boom", logs.TakeAll()[0].ContextMap()["err"])
+}
@@ -0,0 +1,68 @@
+package zap2slog
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+)
+
+// frameCacheSize is the number of frames a frameCache holds.
+const frameCacheSize = 1024
+
+// frameCache is a bounded LRU cache of the frames for PCs.  The frame for a PC never changes,
+// so cached frames never go stale.
+type frameCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[uintptr]*list.Element
+	order   *list.List
+}
+
+type frameCacheEntry struct {
+	pc    uintptr
+	frame runtime.Frame
+}
+
+func newFrameCache(size int) *frameCache {
+	return &frameCache{
+		size:    size,
+		entries: make(map[uintptr]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// frame returns the frame for pc, resolving it with resolve if it isn't cached.
+func (c *frameCache) frame(pc uintptr, resolve func(uintptr) runtime.Frame) runtime.Frame {
+	c.mu.Lock()
+	if el, ok := c.entries[pc]; ok {
+		c.order.MoveToFront(el)
+		f := el.Value.(*frameCacheEntry).frame
+		c.mu.Unlock()
+		return f
+	}
+	c.mu.Unlock()
+
+	// resolve outside the lock.  Concurrent misses for the same PC resolve the same frame.
+	f := resolve(pc)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[pc]; ok {
+		c.order.MoveToFront(el)
+		return f
+	}
+	c.entries[pc] = c.order.PushFront(&frameCacheEntry{pc: pc, frame: f})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*frameCacheEntry).pc)
+	}
+	return f
+}
+
+// len returns the number of cached frames.
+func (c *frameCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
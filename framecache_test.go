@@ -0,0 +1,78 @@
+package zap2slog
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFrameCache(t *testing.T) {
+	var resolved int
+	resolve := func(pc uintptr) runtime.Frame {
+		resolved++
+		return runtime.Frame{PC: pc, Line: int(pc)}
+	}
+
+	c := newFrameCache(2)
+	assert.Equal(t, 1, c.frame(1, resolve).Line)
+	assert.Equal(t, 1, c.frame(1, resolve).Line)
+	assert.Equal(t, 1, resolved)
+
+	assert.Equal(t, 2, c.frame(2, resolve).Line)
+	// 1 is now the most recently used, so 2 is evicted
+	c.frame(1, resolve)
+	assert.Equal(t, 3, c.frame(3, resolve).Line)
+	assert.Equal(t, 2, c.len())
+	assert.Equal(t, 3, resolved)
+
+	c.frame(1, resolve)
+	assert.Equal(t, 3, resolved)
+	c.frame(2, resolve)
+	assert.Equal(t, 4, resolved)
+}
+
+func TestZapHandler_CacheCallerFrames(t *testing.T) {
+	pc, file, line, _ := runtime.Caller(0)
+
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	h := NewZapHandler(mockCore, &ZapHandlerOptions{AddSource: true, CacheCallerFrames: true})
+	child := h.WithGroup("g")
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, child.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", pc)))
+		assert.Equal(t, zapcore.EntryCaller{Defined: true, PC: pc, File: file, Line: line}, mockCore.lastEntry.Caller)
+	}
+	// shared with derived handlers
+	assert.Equal(t, 1, h.frames.len())
+
+	assert.Nil(t, h.WithOptions(func(o *ZapHandlerOptions) { o.CacheCallerFrames = false }).frames)
+	assert.Nil(t, NewZapHandler(mockCore, &ZapHandlerOptions{AddSource: true}).frames)
+}
+
+func BenchmarkZapHandler_AddSource(b *testing.B) {
+	pc, _, _, _ := runtime.Caller(0)
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(DefaultEncoderConfig()), zapcore.AddSync(io.Discard), zapcore.InfoLevel)
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "benchmark", pc)
+	r.AddAttrs(slog.String("method", "POST"))
+
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			h := NewZapHandler(core, &ZapHandlerOptions{AddSource: true, CacheCallerFrames: cached})
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = h.Handle(context.Background(), r)
+			}
+		})
+	}
+}
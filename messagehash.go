@@ -0,0 +1,22 @@
+package zap2slog
+
+import (
+	"hash/fnv"
+	"regexp"
+	"strconv"
+)
+
+// variableParts matches the parts of a message which usually vary between calls
+// with the same message template: UUIDs, hex numbers, and decimal numbers.
+var variableParts = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|0[xX][0-9a-fA-F]+|[0-9]+(?:\.[0-9]+)?`)
+
+// MessageHash returns a short, stable hash of the message's template, for grouping
+// parameterized messages in log analytics.  UUIDs and numbers in the message are masked
+// before hashing, so "retry 1 of 3" and "retry 2 of 3" have the same hash.
+//
+// This is the value added by the MessageHashKey options.
+func MessageHash(msg string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(variableParts.ReplaceAllLiteralString(msg, "?")))
+	return strconv.FormatUint(uint64(h.Sum32()), 16)
+}
@@ -0,0 +1,84 @@
+package zap2slog
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMessageHash(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{a: "retry 1 of 3", b: "retry 2 of 3", same: true},
+		{a: "took 1.5s", b: "took 20.25s", same: true},
+		{a: "user 6ba7b810-9dad-11d1-80b4-00c04fd430c8 logged in", b: "user 123e4567-E89B-12d3-a456-426614174000 logged in", same: true},
+		{a: "addr 0x1f", b: "addr 0xC0FFEE", same: true},
+		{a: "retry 1 of 3", b: "retry 1 of 3 failed"},
+		{a: "user logged in", b: "user logged out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"|"+tt.b, func(t *testing.T) {
+			ha, hb := MessageHash(tt.a), MessageHash(tt.b)
+			assert.NotEmpty(t, ha)
+			if tt.same {
+				assert.Equal(t, ha, hb)
+			} else {
+				assert.NotEqual(t, ha, hb)
+			}
+		})
+	}
+}
+
+func TestSlogCore_MessageHashKey(t *testing.T) {
+	var buf strings.Builder
+	l := NewZapLogger(slog.NewJSONHandler(&buf, nil), &SlogCoreOptions{MessageHashKey: "msg_hash"})
+
+	l.Info("processed 10 items")
+	l.Info("processed 25 items")
+	l.Info("processing done")
+
+	var hashes []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		hashes = append(hashes, m["msg_hash"].(string))
+	}
+	require.Len(t, hashes, 3)
+	assert.Equal(t, MessageHash("processed 10 items"), hashes[0])
+	assert.Equal(t, hashes[0], hashes[1])
+	assert.NotEqual(t, hashes[0], hashes[2])
+
+	// not added by default
+	buf.Reset()
+	NewZapLogger(slog.NewJSONHandler(&buf, nil), nil).Info("processed 10 items")
+	assert.NotContains(t, buf.String(), "msg_hash")
+}
+
+func TestZapHandler_MessageHashKey(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := NewLogger(core, &ZapHandlerOptions{MessageHashKey: "msg_hash"})
+
+	// the hash field is top-level, even with open groups
+	l.WithGroup("g").Info("processed 10 items", "a", 1)
+	l.Info("processed 25 items")
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 2)
+	assert.Equal(t, []zapcore.Field{
+		zap.Any("g", []zapcore.Field{zap.Int64("a", 1)}),
+		zap.String("msg_hash", MessageHash("processed 10 items")),
+	}, entries[0].Context)
+	assert.Equal(t, entries[0].ContextMap()["msg_hash"], entries[1].ContextMap()["msg_hash"])
+
+	NewLogger(core, nil).Info("processed 10 items")
+	assert.Empty(t, logs.TakeAll()[0].Context)
+}
@@ -0,0 +1,15 @@
+package zap2slog
+
+import "log/slog"
+
+// RoundTrip returns a handler which converts records to zap entries with a ZapHandler, then
+// back to records with a SlogCore, which writes them to h.  It is intended for testing that
+// attributes survive the conversion in both directions.
+//
+// For common attribute types, the records h receives are equivalent to the ones passed to the
+// returned handler, including the source.  Conversion is lossy in a few ways: levels are mapped
+// to the nearest zap level, errors become strings, and groups added with WithGroup or WithAttrs
+// are added to each record instead of to h.
+func RoundTrip(h slog.Handler) slog.Handler {
+	return NewZapHandler(NewSlogCore(h, nil), &ZapHandlerOptions{AddSource: true})
+}
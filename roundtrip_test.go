@@ -0,0 +1,90 @@
+package zap2slog
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansel1/zap2slog/zap2slogtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripStruct struct {
+	Name string
+}
+
+func TestRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	// one attr of every kind
+	attrs := []slog.Attr{
+		slog.Any("any", roundTripStruct{Name: "alice"}),
+		slog.Bool("bool", true),
+		slog.Duration("duration", 3*time.Second),
+		slog.Float64("float64", 1.5),
+		slog.Int64("int64", -7),
+		slog.String("string", "hello"),
+		slog.Time("time", ts),
+		slog.Uint64("uint64", 7),
+		slog.Group("group", slog.String("a", "b"), slog.Group("nested", slog.Int("c", 1))),
+		slog.Any("logvaluer", logValuerFunc(func() slog.Value { return slog.StringValue("resolved") })),
+	}
+
+	pc, _, _, _ := runtime.Caller(0)
+	newRecord := func() slog.Record {
+		r := slog.NewRecord(ts, slog.LevelWarn, "msg", pc)
+		r.AddAttrs(attrs...)
+		return r
+	}
+
+	h := zap2slogtest.NewRecordingHandler(nil)
+	require.NoError(t, RoundTrip(h).Handle(context.Background(), newRecord()))
+	records := h.Records()
+	require.Len(t, records, 1)
+	got := records[0]
+
+	want := newRecord()
+	assert.Equal(t, want.Time, got.Time)
+	assert.Equal(t, want.Level, got.Level)
+	assert.Equal(t, want.Message, got.Message)
+	assert.Equal(t, want.PC, got.PC)
+
+	var wantAttrs, gotAttrs []slog.Attr
+	want.Attrs(func(a slog.Attr) bool {
+		a.Value = a.Value.Resolve()
+		wantAttrs = append(wantAttrs, a)
+		return true
+	})
+	got.Attrs(func(a slog.Attr) bool {
+		gotAttrs = append(gotAttrs, a)
+		return true
+	})
+	require.Len(t, gotAttrs, len(wantAttrs))
+	for i := range wantAttrs {
+		assert.True(t, wantAttrs[i].Equal(gotAttrs[i]), "want %v, got %v", wantAttrs[i], gotAttrs[i])
+		assert.Equal(t, wantAttrs[i].Value.Kind(), gotAttrs[i].Value.Kind(), wantAttrs[i].Key)
+	}
+
+	t.Run("same output", func(t *testing.T) {
+		var direct, roundTripped strings.Builder
+		opts := &slog.HandlerOptions{AddSource: true}
+		log := func(h slog.Handler) {
+			slog.New(h).With("service", "api").WithGroup("req").Warn("msg", "id", 1, slog.Group("user", "name", "alice"))
+		}
+		log(slog.NewJSONHandler(&direct, opts))
+		log(RoundTrip(slog.NewJSONHandler(&roundTripped, opts)))
+
+		// the times differ
+		strip := func(s string) map[string]any {
+			var m map[string]any
+			require.NoError(t, json.Unmarshal([]byte(s), &m))
+			delete(m, slog.TimeKey)
+			return m
+		}
+		assert.Equal(t, strip(direct.String()), strip(roundTripped.String()))
+	})
+}
@@ -1,27 +1,269 @@
 package zap2slog
 
 import (
+	"bytes"
 	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"reflect"
+	"runtime"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 // SlogCore implements zapcore.Core
 var _ zapcore.Core = (*SlogCore)(nil)
 
+// DefaultLevelLabelKey is the key of the LevelLabels attribute, if LevelLabelKey isn't set.
+const DefaultLevelLabelKey = "level_label"
+
 type SlogCoreOptions struct {
 	// LoggerNameKey adds an attribute to slog.Records containing the zap logger name.
 	// If LoggerNameKey is empty, or the zap logger name is empty, then no attribute is added.
+	// If one of the zap fields has the same key, that field is dropped in favor of the logger name.
 	LoggerNameKey string
+	// LoggerNameKeys are alternate keys for the logger name attribute.  The keys are checked in order,
+	// starting with LoggerNameKey, and the first one which isn't already used by one of the entry's
+	// top-level fields is used.  If all the keys are used, the first key is used.
+	LoggerNameKeys []string
+	// LoggerNameSeparator, if set, splits the zap logger name on this separator, and the
+	// LoggerNameKey attribute's value will be a []string of the name's segments, rather than a string.
+	// zap's separator is ".", so a logger named "a.b.c" would have the value []string{"a", "b", "c"}.
+	LoggerNameSeparator string
+	// AddSource adds a slog.SourceKey group attribute to slog.Records, built from the zap entry's caller.
+	// This is independent of the slog.Handler's own AddSource setting, so it should typically only be
+	// used with handlers which don't add source themselves, or the source will appear twice.
+	// If the zap entry's caller is undefined, no attribute is added.
+	AddSource bool
+	// LazyFields defers encoding the zap fields into slog attributes until the slog.Handler
+	// resolves them.  The fields are added to the slog.Record as a single slog.LogValuer attribute with
+	// an empty key, which resolves to an inlined group.  This saves work with handlers that may
+	// drop records in Handle, after Enabled has already returned true.
+	LazyFields bool
+	// RoundFloat32 rounds float32 field values to float32 precision when they are widened to
+	// float64 slog values.  Without this, a float32 value like 0.1 will be rendered by slog
+	// handlers as 0.10000000149011612.
+	RoundFloat32 bool
+	// ReflectStructsAsGroups decomposes struct values added with zap.Reflect (or zap.Any, when it
+	// falls back to reflection) into groups, with an attribute for each exported struct field.  Fields
+	// are named and skipped according to their json tags, and the fields of embedded structs are
+	// promoted, like zap's reflected encoding.  Nested
+	// structs become nested groups, and structs inside slices become maps.  Structs which encode
+	// themselves, like time.Time or types implementing encoding.TextMarshaler or json.Marshaler,
+	// are not decomposed.  Without this, the struct value is passed to slog as is.
+	ReflectStructsAsGroups bool
+	// RequireFields drops zap entries which have no fields, after the fields have been
+	// converted to slog attributes.  The logger name and source attributes don't count as fields.
+	// With LazyFields, the unconverted zap fields are counted instead.
+	RequireFields bool
+	// SyncFunc is called by SlogCore.Sync.  If nil, Sync will call the slog.Handler's Sync() or
+	// Flush() method, if it has one.  Set SyncFunc to flush handlers which don't expose a
+	// flush method, like a slog.TextHandler writing to a buffered writer.
+	SyncFunc func() error
+	// SeverityKey adds an integer attribute to slog.Records, containing a severity number mapped
+	// from the zap level by SeverityLevels.  If SeverityKey is empty, or the level isn't in the
+	// table, no attribute is added.
+	SeverityKey string
+	// SeverityLevels maps zap levels to the severity numbers used by SeverityKey.  If nil,
+	// SyslogSeverities is used.
+	SeverityLevels map[zapcore.Level]int
+	// ReplaceAttr is called to rewrite each non-group attribute converted from the zap fields,
+	// before it is added to the slog.Record.  If it returns an empty attribute, the attribute is
+	// dropped.  See slog.HandlerOptions.ReplaceAttr.  It's also called on the logger name attribute
+	// added by LoggerNameKey, with no groups, so the logger name can be transformed or dropped.
+	ReplaceAttr func(groups []string, a slog.Attr) slog.Attr
+	// ReplaceAttrByLogger maps zap logger names to ReplaceAttr functions.  If the entry's logger name
+	// is in the map, that function is used instead of ReplaceAttr.
+	ReplaceAttrByLogger map[string]func(groups []string, a slog.Attr) slog.Attr
+	// CoalesceWindow, if positive, coalesces consecutive identical zap entries.  The first entry is
+	// written, and identical entries following it within the window are suppressed.  When a different
+	// entry is written, the window expires, or the core is synced, the last suppressed entry is written
+	// with a RepeatedKey field containing the number of suppressed entries.  Entries are identical
+	// if they have the same level, logger name, message, and fields.
+	CoalesceWindow time.Duration
+	// ShortSourceKey, if set, adds an attribute with this key containing the zap entry's trimmed caller
+	// path and line, like "pkg/file.go:12".  The record's PC is still set, so handlers with AddSource
+	// will also add the full path.  If the zap entry's caller is undefined, no attribute is added.
+	ShortSourceKey string
+	// FlushOnLevel, if set, syncs the handler after writing an entry at or above this level, so the
+	// entry isn't lost if the process crashes.  The handler is synced the same way as SlogCore.Sync,
+	// via SyncFunc or the handler's Sync or Flush method.
+	FlushOnLevel slog.Leveler
+	// MessageHashKey, if set, adds an attribute with this key containing a hash of the entry's
+	// message, with numbers and UUIDs masked.  See MessageHash.
+	MessageHashKey string
+	// DropEmptyKeys drops fields with empty keys, like zap.String("", "v"), which slog handlers
+	// may render oddly.  Objects and namespaces with empty keys are kept, since slog handlers
+	// inline groups with empty keys.  By default, empty-key fields are passed through as is.
+	DropEmptyKeys bool
+	// FlattenNamespaces prefixes the keys of the fields in a zap namespace with the namespace's key and
+	// a ".", e.g. "request.user.id", instead of nesting them in a slog group.  This gives flat, dotted
+	// keys even with handlers like slog.JSONHandler, which render groups as nested objects.
+	// ReplaceAttr is called with the prefixed keys.
+	FlattenNamespaces bool
+	// Clock, if set, provides the time for entries with a zero time.  Use SystemClock for the current
+	// time.  Otherwise, the zero time is passed to the slog handler, which usually omits it.  The
+	// core also uses it, or SystemClock if it isn't set, to time handlers for OnSlowHandle and to
+	// timestamp the records written to DiagnosticHandler.
+	Clock Clock
+	// CompactGroups renders groups, including namespaces and objects, as a single string attribute
+	// like "{a=1 b={c=2}}", which is easier to scan in console output than dotted keys.  It is
+	// applied after ReplaceAttr.
+	CompactGroups bool
+	// ErrorKey, if set, renames error fields created with zap.Error, which always have the key "error".
+	// Error fields with other keys, e.g. from zap.NamedError, and error fields nested in objects, are
+	// not renamed.  ReplaceAttr is called with the new key.
+	ErrorKey string
+	// KeepPartialOnError keeps arrays of objects when an object fails to marshal.  The object is
+	// kept with the fields added before the error, plus an "error" field with the error message.
+	// Otherwise, like zap, the whole array is dropped and replaced with a "<key>Error" field.
+	KeepPartialOnError bool
+	// DiagnosticHandler, if set, receives warnings about likely misconfiguration, such as a named
+	// logger's name being dropped because LoggerNameKey isn't set.  Each warning is only written
+	// once per core, including the cores derived from it with With.
+	DiagnosticHandler slog.Handler
+	// OnSlowHandle, if set, is called after the slog.Handler takes longer than SlowHandleThreshold
+	// to handle a record, to help find slow log sinks.  It's called with the record and the time
+	// Handle took.  It shouldn't log through the same core, or it may recurse.
+	OnSlowHandle func(r slog.Record, elapsed time.Duration)
+	// SlowHandleThreshold is the threshold for OnSlowHandle.  If zero, OnSlowHandle is called
+	// for every record.
+	SlowHandleThreshold time.Duration
+	// LoggerNameAsGroup nests the attributes converted from the zap fields in a group named after
+	// the entry's logger name.  If LoggerNameSeparator is set, each segment of the name opens a
+	// nested group, so fields from logger "db.pool" nest under "db", then "pool".
+	LoggerNameAsGroup bool
+	// TimestampKey, if set, adds an attribute with this key containing the entry's time formatted
+	// with TimestampLayout, in addition to the record's time.  This is for consumers which can't
+	// parse the handler's time format.
+	TimestampKey string
+	// TimestampLayout is the time layout used by TimestampKey.  If empty, ISO8601BasicLayout is used.
+	TimestampLayout string
+	// ExtractErrorStacks converts top-level error fields whose errors record a stack trace, like
+	// errors from github.com/pkg/errors, to a group with the error's message under "msg" and the
+	// stack's frames under ErrorStackKey.  Errors without a stack trace are converted as usual.
+	ExtractErrorStacks bool
+	// ErrorStackKey is the key of the stack frames added by ExtractErrorStacks.  If empty,
+	// DefaultErrorStackKey is used.
+	ErrorStackKey string
+	// LevelLabels maps zap levels to custom level names, like "warning" for zapcore.WarnLevel.  The
+	// record's level is a slog.Level, which the handler renders itself, so the name is added as an
+	// attribute with LevelLabelKey.  If the entry's level isn't in the map, no attribute is added.
+	LevelLabels map[zapcore.Level]string
+	// LevelLabelKey is the key of the LevelLabels attribute.  If empty, DefaultLevelLabelKey is used.
+	// To replace the handler's level with the label, set it to slog.LevelKey, and set the handler's
+	// ReplaceAttr to drop its own level: return an empty attribute for slog.LevelKey when its value
+	// is a slog.Level.  The label attribute's value is a string.
+	LevelLabelKey string
+	// OnWrite, if set, is called with each entry which is written to the slog.Handler, just before it's
+	// written, e.g. to count entries per level.  That includes the repeated entries written by
+	// CoalesceWindow, but not the entries CoalesceWindow or RequireFields suppress.
+	OnWrite func(zapcore.Entry)
+	// OnDrop, if set, is called from Check with each entry which is dropped because its level
+	// isn't enabled by the core, e.g. when the core is teed with cores which enable the level, so
+	// each entry is counted once per SlogCore which drops it.  zap.Logger calls Enabled before
+	// Check, and doesn't call Check for levels no core enables, so those entries aren't counted.
+	OnDrop func(zapcore.Entry)
+	// DurationEncoding controls how durations are converted.  The default, DurationString, converts
+	// them to slog.Duration values, which slog's handlers render like "1.5s".  The other encodings
+	// match zap's duration encoders, e.g. DurationSeconds is zap's default.
+	DurationEncoding DurationEncoding
 }
 
+// DurationEncoding is how SlogCore converts durations.  See SlogCoreOptions.DurationEncoding.
+type DurationEncoding string
+
+const (
+	// DurationString converts durations to slog.Duration values.
+	DurationString DurationEncoding = "string"
+	// DurationSeconds converts durations to float64 seconds, like zapcore.SecondsDurationEncoder.
+	DurationSeconds DurationEncoding = "seconds"
+	// DurationMillis converts durations to float64 milliseconds, like zapcore.MillisDurationEncoder.
+	DurationMillis DurationEncoding = "millis"
+	// DurationNanos converts durations to int64 nanoseconds, like zapcore.NanosDurationEncoder.
+	DurationNanos DurationEncoding = "nanos"
+)
+
+// durationValue converts d according to the encoding.
+func durationValue(enc DurationEncoding, d time.Duration) slog.Value {
+	switch enc {
+	case DurationSeconds:
+		return slog.Float64Value(d.Seconds())
+	case DurationMillis:
+		return slog.Float64Value(float64(d) / float64(time.Millisecond))
+	case DurationNanos:
+		return slog.Int64Value(int64(d))
+	default:
+		return slog.DurationValue(d)
+	}
+}
+
+// ISO8601BasicLayout is the ISO 8601 basic format, like "20240101T120000Z".
+const ISO8601BasicLayout = "20060102T150405Z0700"
+
+// replaceAttrFor returns the ReplaceAttr function for the logger name.
+func (o *SlogCoreOptions) replaceAttrFor(loggerName string) func(groups []string, a slog.Attr) slog.Attr {
+	if fn, ok := o.ReplaceAttrByLogger[loggerName]; ok {
+		return fn
+	}
+	return o.ReplaceAttr
+}
+
+// SyslogSeverities maps zap levels to syslog severity numbers (RFC 5424).
+var SyslogSeverities = map[zapcore.Level]int{
+	zapcore.DebugLevel:  7,
+	zapcore.InfoLevel:   6,
+	zapcore.WarnLevel:   4,
+	zapcore.ErrorLevel:  3,
+	zapcore.DPanicLevel: 2,
+	zapcore.PanicLevel:  1,
+	zapcore.FatalLevel:  0,
+}
+
+// SlogCore is a zapcore.Core which writes to a slog.Handler.  It is safe for concurrent use,
+// including deriving child cores from the same parent with With, provided the handler is too.
 type SlogCore struct {
-	h      slog.Handler
-	opts   SlogCoreOptions
-	fields []zapcore.Field
+	h         slog.Handler
+	lvl       zapcore.LevelEnabler
+	opts      SlogCoreOptions
+	fields    []zapcore.Field
+	coalescer *coalescer
+	diag      *diagnostics
+}
+
+// diagnostics writes one-time warnings to SlogCoreOptions.DiagnosticHandler.
+type diagnostics struct {
+	h                 slog.Handler
+	clock             Clock
+	droppedLoggerName sync.Once
+}
+
+func newDiagnostics(h slog.Handler, clock Clock) *diagnostics {
+	if h == nil {
+		return nil
+	}
+	return &diagnostics{h: h, clock: orSystemClock(clock)}
+}
+
+func (d *diagnostics) warn(msg string, attrs ...slog.Attr) {
+	ctx := context.Background()
+	if !d.h.Enabled(ctx, slog.LevelWarn) {
+		return
+	}
+	rec := slog.NewRecord(d.clock.Now(), slog.LevelWarn, msg, 0)
+	rec.AddAttrs(attrs...)
+	_ = d.h.Handle(ctx, rec)
 }
 
 func NewSlogCore(h slog.Handler, opts *SlogCoreOptions) *SlogCore {
@@ -29,13 +271,52 @@ func NewSlogCore(h slog.Handler, opts *SlogCoreOptions) *SlogCore {
 		opts = &SlogCoreOptions{}
 	}
 	return &SlogCore{
-		h:    h,
-		opts: *opts,
+		h:         h,
+		opts:      *opts,
+		coalescer: newCoalescer(opts.CoalesceWindow),
+		diag:      newDiagnostics(opts.DiagnosticHandler, opts.Clock),
 	}
 }
 
+// NewSlogCoreWithLevel returns a SlogCore which is only enabled for levels enabled by both lvl and
+// the slog.Handler.  lvl is checked first, so levels can be changed on the zap side, e.g. with a
+// zap.AtomicLevel, even if the handler's level is fixed.  If lvl is nil, it's the same as NewSlogCore.
+func NewSlogCoreWithLevel(h slog.Handler, lvl zapcore.LevelEnabler, opts *SlogCoreOptions) *SlogCore {
+	c := NewSlogCore(h, opts)
+	c.lvl = lvl
+	return c
+}
+
+// NewSampledSlogCore returns a SlogCore wrapped in a zap sampler.  Each tick, the first entries with a
+// given level and message are logged, and after that, every thereafter-th entry.
+// See zapcore.NewSamplerWithOptions.
+func NewSampledSlogCore(h slog.Handler, opts *SlogCoreOptions, tick time.Duration, first, thereafter int) zapcore.Core {
+	return zapcore.NewSamplerWithOptions(NewSlogCore(h, opts), tick, first, thereafter)
+}
+
+// NewZapLogger returns a zap.Logger which writes to a SlogCore.  zapOpts are passed to zap.New.
+func NewZapLogger(h slog.Handler, opts *SlogCoreOptions, zapOpts ...zap.Option) *zap.Logger {
+	return zap.New(NewSlogCore(h, opts), zapOpts...)
+}
+
+// Enabled reports whether the core's LevelEnabler, if any, and the slog.Handler are enabled for
+// the level.
 func (c *SlogCore) Enabled(l zapcore.Level) bool {
-	return c.h.Enabled(context.Background(), zapToSlogLvl(l))
+	if c.lvl != nil && !c.lvl.Enabled(l) {
+		return false
+	}
+	return c.h.Enabled(context.Background(), ZapToSlogLevel(l))
+}
+
+// Level returns the minimum level enabled by the core's LevelEnabler, if any, and the slog.Handler,
+// the same way as zapcore.LevelOf.
+func (c *SlogCore) Level() zapcore.Level {
+	for l := zapcore.DebugLevel; l <= zapcore.FatalLevel; l++ {
+		if c.Enabled(l) {
+			return l
+		}
+	}
+	return zapcore.InvalidLevel
 }
 
 func (c *SlogCore) With(fields []zapcore.Field) zapcore.Core {
@@ -49,48 +330,440 @@ func (c *SlogCore) With(fields []zapcore.Field) zapcore.Core {
 	// slog.Handler with open groups in the Write() call, and I can't
 	// add any non-group-scoped attributes at that point.
 	return &SlogCore{
-		h:      c.h,
-		opts:   c.opts,
-		fields: slices.Clip(append(c.fields, fields...)),
+		h:         c.h,
+		lvl:       c.lvl,
+		opts:      c.opts,
+		fields:    concatFields(c.fields, fields),
+		coalescer: c.coalescer,
+		diag:      c.diag,
 	}
 }
 
+// concatFields returns a new slice containing a followed by b.  It never appends to a's
+// backing array, so cores derived concurrently from the same parent don't race.
+func concatFields(a, b []zapcore.Field) []zapcore.Field {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+	fields := make([]zapcore.Field, 0, len(a)+len(b))
+	return append(append(fields, a...), b...)
+}
+
 func (c *SlogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
 	if c.Enabled(e.Level) {
 		return ce.AddCore(e, c)
 	}
+	if c.opts.OnDrop != nil {
+		c.opts.OnDrop(e)
+	}
 	return nil
 }
 
 func (c *SlogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Time = defaultTime(c.opts.Clock, e.Time)
+	fields = concatFields(c.fields, fields)
+
+	if c.coalescer != nil {
+		suppressed, pending := c.coalescer.suppress(c, e, fields)
+		err := pending.write()
+		if suppressed {
+			return err
+		}
+		return errors.Join(err, c.write(e, fields))
+	}
+	return c.write(e, fields)
+}
+
+// write writes the entry, then syncs the handler if the entry's level is at least FlushOnLevel.
+func (c *SlogCore) write(e zapcore.Entry, fields []zapcore.Field) error {
+	if err := c.writeEntry(e, fields); err != nil {
+		return err
+	}
+
+	if c.opts.FlushOnLevel != nil && ZapToSlogLevel(e.Level) >= c.opts.FlushOnLevel.Level() {
+		return c.syncHandler()
+	}
+	return nil
+}
+
+// writeRepeated writes a coalesced entry, the same way as Write.
+func (c *SlogCore) writeRepeated(e zapcore.Entry, fields []zapcore.Field) error {
+	return c.write(e, fields)
+}
+
+// writeEntry writes the entry to the slog.Handler.  fields should include the
+// fields added with With.
+func (c *SlogCore) writeEntry(e zapcore.Entry, fields []zapcore.Field) error {
+	var loggerNameKey string
+	if strings.TrimSpace(e.LoggerName) != "" {
+		loggerNameKey = c.loggerNameKey(fields)
+		if c.diag != nil && c.opts.LoggerNameKey == "" && len(c.opts.LoggerNameKeys) == 0 && !c.opts.LoggerNameAsGroup {
+			c.diag.droppedLoggerName.Do(func() {
+				c.diag.warn("zap2slog: dropping logger names, because SlogCoreOptions.LoggerNameKey is not set",
+					slog.String("loggerName", e.LoggerName))
+			})
+		}
+	}
+	if loggerNameKey != "" {
+		// the entry's logger name takes precedence over a field with the same key
+		fields = withoutTopLevelKey(fields, loggerNameKey)
+	}
+
+	replace := c.opts.replaceAttrFor(e.LoggerName)
+
+	var attrs []slog.Attr
+	if c.opts.LazyFields {
+		if len(fields) > 0 {
+			attrs = []slog.Attr{slog.Any("", lazyFields{fields: fields, opts: &c.opts, replace: replace})}
+		}
+	} else {
+		// rec.AddAttrs copies the attrs into the record, so the encoder can be
+		// returned to the pool once the record is handled
+		enc := getObjEnc(&c.opts)
+		defer putObjEnc(enc)
+		attrs = enc.encode(fields, replace)
+	}
+
+	if c.opts.RequireFields && len(attrs) == 0 {
+		return nil
+	}
+
+	if c.opts.OnWrite != nil {
+		c.opts.OnWrite(e)
+	}
+
+	if c.opts.LoggerNameAsGroup && e.LoggerName != "" && len(attrs) > 0 {
+		attrs = c.loggerNameGroup(e.LoggerName, attrs)
+	}
+
 	var pc uintptr
 	if e.Caller.Defined {
 		pc = e.Caller.PC
 	}
 
-	rec := slog.NewRecord(e.Time, zapToSlogLvl(e.Level), e.Message, pc)
+	rec := slog.NewRecord(e.Time, ZapToSlogLevel(e.Level), e.Message, pc)
+
+	// the logger name attribute is added directly to the record, so it is never
+	// nested inside a namespace opened by the fields
+	if loggerNameKey != "" {
+		var a slog.Attr
+		if c.opts.LoggerNameSeparator != "" {
+			a = slog.Any(loggerNameKey, strings.Split(e.LoggerName, c.opts.LoggerNameSeparator))
+		} else {
+			a = slog.String(loggerNameKey, e.LoggerName)
+		}
+		if replace != nil {
+			a = replace(nil, a)
+			a.Value = a.Value.Resolve()
+		}
+		if !a.Equal(slog.Attr{}) {
+			rec.AddAttrs(a)
+		}
+	}
+
+	if label, ok := c.opts.LevelLabels[e.Level]; ok {
+		key := c.opts.LevelLabelKey
+		if key == "" {
+			key = DefaultLevelLabelKey
+		}
+		rec.AddAttrs(slog.String(key, label))
+	}
+
+	if c.opts.SeverityKey != "" {
+		severities := c.opts.SeverityLevels
+		if severities == nil {
+			severities = SyslogSeverities
+		}
+		if sev, ok := severities[e.Level]; ok {
+			rec.AddAttrs(slog.Int(c.opts.SeverityKey, sev))
+		}
+	}
+
+	if c.opts.AddSource && e.Caller.Defined {
+		rec.AddAttrs(sourceAttr(e.Caller))
+	}
+
+	if c.opts.ShortSourceKey != "" && e.Caller.Defined {
+		rec.AddAttrs(slog.String(c.opts.ShortSourceKey, callerWithFile(e.Caller).TrimmedPath()))
+	}
+
+	if c.opts.MessageHashKey != "" {
+		rec.AddAttrs(slog.String(c.opts.MessageHashKey, MessageHash(e.Message)))
+	}
+
+	if c.opts.TimestampKey != "" {
+		layout := c.opts.TimestampLayout
+		if layout == "" {
+			layout = ISO8601BasicLayout
+		}
+		rec.AddAttrs(slog.String(c.opts.TimestampKey, e.Time.Format(layout)))
+	}
+
+	rec.AddAttrs(attrs...)
 
-	if c.opts.LoggerNameKey != "" && e.LoggerName != "" {
-		rec.AddAttrs(slog.String(c.opts.LoggerNameKey, e.LoggerName))
+	if c.opts.OnSlowHandle == nil {
+		return c.h.Handle(context.Background(), rec)
 	}
+	clock := orSystemClock(c.opts.Clock)
+	start := clock.Now()
+	err := c.h.Handle(context.Background(), rec)
+	if elapsed := clock.Now().Sub(start); elapsed >= c.opts.SlowHandleThreshold {
+		c.opts.OnSlowHandle(rec, elapsed)
+	}
+	return err
+}
 
-	fields = append(c.fields, fields...)
+// loggerNameGroup nests attrs in groups named after the logger name.  attrs may be from a pooled
+// encoder, so they're copied before becoming the members of a group value.
+func (c *SlogCore) loggerNameGroup(loggerName string, attrs []slog.Attr) []slog.Attr {
+	attrs = slices.Clone(attrs)
+	names := []string{loggerName}
+	if c.opts.LoggerNameSeparator != "" {
+		names = strings.Split(loggerName, c.opts.LoggerNameSeparator)
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		attrs = []slog.Attr{{Key: names[i], Value: slog.GroupValue(attrs...)}}
+	}
+	return attrs
+}
 
-	var enc slogObjEnc
+// loggerNameKey returns the first of LoggerNameKey and LoggerNameKeys which isn't
+// the key of one of the top-level fields.
+func (c *SlogCore) loggerNameKey(fields []zapcore.Field) string {
+	if len(c.opts.LoggerNameKeys) == 0 {
+		return c.opts.LoggerNameKey
+	}
+
+	first := c.opts.LoggerNameKey
+	if first != "" && !hasTopLevelKey(fields, first) {
+		return first
+	}
+	for _, key := range c.opts.LoggerNameKeys {
+		if key == "" {
+			continue
+		}
+		if first == "" {
+			first = key
+		}
+		if !hasTopLevelKey(fields, key) {
+			return key
+		}
+	}
+	return first
+}
+
+// hasTopLevelKey returns true if one of the fields has the key, and is not inside a namespace.
+func hasTopLevelKey(fields []zapcore.Field, key string) bool {
 	for _, f := range fields {
-		f.AddTo(&enc)
+		if f.Key == key {
+			return true
+		}
+		if f.Type == zapcore.NamespaceType {
+			// all subsequent fields are in the namespace
+			return false
+		}
 	}
+	return false
+}
 
-	rec.AddAttrs(enc.finalAttrs()...)
+// withoutTopLevelKey returns the fields, minus any with the key which are not inside
+// a namespace.  fields is not modified: if any fields are removed, a new slice is returned.
+func withoutTopLevelKey(fields []zapcore.Field, key string) []zapcore.Field {
+	if !hasTopLevelKey(fields, key) {
+		return fields
+	}
+	filtered := make([]zapcore.Field, 0, len(fields)-1)
+	for i, f := range fields {
+		if f.Type == zapcore.NamespaceType {
+			// all subsequent fields are in the namespace.  The namespace itself is kept
+			// even if its key matches, since dropping it would un-nest the subsequent fields.
+			return append(append(filtered, f), fields[i+1:]...)
+		}
+		if f.Key != key {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered
+}
 
-	return c.h.Handle(context.Background(), rec)
+// callerWithFile returns the caller, with the file and line resolved from the PC
+// if they're missing.
+func callerWithFile(caller zapcore.EntryCaller) zapcore.EntryCaller {
+	if caller.File == "" && caller.PC != 0 {
+		fs := runtime.CallersFrames([]uintptr{caller.PC})
+		f, _ := fs.Next()
+		caller.File, caller.Line, caller.Function = f.File, f.Line, f.Function
+	}
+	return caller
+}
+
+// sourceAttr builds a group attribute from the zap caller, with the same
+// keys slog's built-in handlers use for their source attribute.
+func sourceAttr(caller zapcore.EntryCaller) slog.Attr {
+	return slog.Group(slog.SourceKey,
+		slog.String("function", caller.Function),
+		slog.String("file", caller.File),
+		slog.Int("line", caller.Line),
+	)
 }
 
 func (c *SlogCore) Sync() error {
+	if c.coalescer != nil {
+		if err := c.coalescer.flush(); err != nil {
+			return err
+		}
+	}
+	return c.syncHandler()
+}
+
+// syncHandler calls SyncFunc, or the slog.Handler's Sync or Flush method.
+func (c *SlogCore) syncHandler() error {
+	if c.opts.SyncFunc != nil {
+		return c.opts.SyncFunc()
+	}
+	switch h := c.h.(type) {
+	case interface{ Sync() error }:
+		return h.Sync()
+	case interface{ Flush() error }:
+		return h.Flush()
+	}
 	return nil
 }
 
-func zapToSlogLvl(zl zapcore.Level) slog.Level {
+// encodeFields converts the fields to attrs.  The returned attrs are newly allocated, and
+// may be retained.
+func encodeFields(fields []zapcore.Field, opts *SlogCoreOptions, replace func(groups []string, a slog.Attr) slog.Attr) []slog.Attr {
+	enc := slogObjEnc{opts: opts}
+	return enc.encode(fields, replace)
+}
+
+// encode adds the fields to the encoder, and returns the final attrs with replace applied.
+// The attrs are backed by the encoder's buffers.
+func (s *slogObjEnc) encode(fields []zapcore.Field, replace func(groups []string, a slog.Attr) slog.Attr) []slog.Attr {
+	for _, f := range fields {
+		s.addField(f)
+	}
+	attrs := s.finalAttrs()
+	if replace != nil {
+		attrs = replaceAttrs(replace, nil, attrs)
+	}
+	if s.opts != nil && s.opts.CompactGroups {
+		compactGroups(attrs)
+	}
+	return attrs
+}
+
+// compactGroups replaces groups with string attrs like "{a=1 b={c=2}}".  Groups with empty keys
+// are kept, since handlers inline them, but their members are compacted.  attrs is modified in place.
+func compactGroups(attrs []slog.Attr) {
+	for i, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Value.Kind() != slog.KindGroup {
+			continue
+		}
+		if a.Key == "" {
+			members := slices.Clone(a.Value.Group())
+			compactGroups(members)
+			attrs[i].Value = slog.GroupValue(members...)
+			continue
+		}
+		var b strings.Builder
+		writeCompactGroup(&b, a.Value.Group())
+		attrs[i] = slog.String(a.Key, b.String())
+	}
+}
+
+func writeCompactGroup(b *strings.Builder, attrs []slog.Attr) {
+	b.WriteByte('{')
+	writeCompactAttrs(b, attrs, true)
+	b.WriteByte('}')
+}
+
+// writeCompactAttrs writes the attrs as space separated key=value pairs, and returns
+// whether the next attr is the first one written.
+func writeCompactAttrs(b *strings.Builder, attrs []slog.Attr, first bool) bool {
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		if v.Kind() == slog.KindGroup && a.Key == "" {
+			first = writeCompactAttrs(b, v.Group(), first)
+			continue
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(compactString(a.Key))
+		b.WriteByte('=')
+		if v.Kind() == slog.KindGroup {
+			writeCompactGroup(b, v.Group())
+		} else {
+			b.WriteString(compactString(v.String()))
+		}
+	}
+	return first
+}
+
+// compactString quotes s if it is empty, or contains characters which would
+// make the compact group ambiguous.
+func compactString(s string) string {
+	if s == "" || strings.ContainsAny(s, " ={}\"\\") || strings.ContainsFunc(s, func(r rune) bool {
+		return !unicode.IsPrint(r)
+	}) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// replaceAttrs applies replace to the attrs and the members of any groups, and drops
+// attrs which are replaced with empty attrs.  attrs is modified in place.
+func replaceAttrs(replace func(groups []string, a slog.Attr) slog.Attr, groups []string, attrs []slog.Attr) []slog.Attr {
+	n := 0
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Value.Kind() == slog.KindGroup {
+			// clone, since a resolved LogValuer's group attrs may not belong to us
+			members := replaceAttrs(replace, append(groups, a.Key), slices.Clone(a.Value.Group()))
+			if len(members) == 0 {
+				continue
+			}
+			a.Value = slog.GroupValue(members...)
+		} else {
+			a = replace(groups, a)
+			a.Value = a.Value.Resolve()
+			if a.Equal(slog.Attr{}) {
+				continue
+			}
+		}
+		attrs[n] = a
+		n++
+	}
+	return attrs[:n]
+}
+
+// lazyFields defers encoding zap fields until the slog.Handler resolves the value.
+// It resolves to a group value, which handlers will inline when the attribute's key is empty.
+type lazyFields struct {
+	fields  []zapcore.Field
+	opts    *SlogCoreOptions
+	replace func(groups []string, a slog.Attr) slog.Attr
+}
+
+func (l lazyFields) LogValue() slog.Value {
+	return slog.GroupValue(encodeFields(l.fields, l.opts, l.replace)...)
+}
+
+// ZapToSlogLevel maps zap levels to slog levels, the same way SlogCore does:
+//
+//	zapcore.DebugLevel     slog.LevelDebug
+//	zapcore.InfoLevel      slog.LevelInfo
+//	zapcore.WarnLevel      slog.LevelWarn
+//	zapcore.ErrorLevel     slog.LevelError
+//	above ErrorLevel       slog.LevelError
+//	below DebugLevel       slog.LevelDebug - 4 per zap level
+//
+// Levels below debug map to proportionally lower slog levels, so handlers enabled below
+// slog.LevelDebug can tell them apart.
+func ZapToSlogLevel(zl zapcore.Level) slog.Level {
 	switch zl {
 	case zapcore.DebugLevel:
 		return slog.LevelDebug
@@ -102,22 +775,116 @@ func zapToSlogLvl(zl zapcore.Level) slog.Level {
 		return slog.LevelError
 	}
 	if zl < zapcore.DebugLevel {
-		return slog.LevelDebug
-	} else {
-		return slog.LevelError
+		return slog.LevelDebug - 4*slog.Level(zapcore.DebugLevel-zl)
 	}
+	return slog.LevelError
+}
+
+// FieldsToAttrs converts zap fields to slog attributes, the same way SlogCore does with default
+// options.  Namespaces open groups containing the fields which follow them.  Use a FieldEncoder
+// to apply options, or to reuse buffers across calls.
+func FieldsToAttrs(fields []zapcore.Field) []slog.Attr {
+	return encodeFields(fields, &SlogCoreOptions{}, nil)
+}
+
+// FieldEncoder converts zap fields to slog attributes, the same way SlogCore does.  It is intended
+// for building custom zapcore.Cores, and can be reused across entries to avoid allocations.
+//
+// Add all of an entry's fields with AddFields, then call Attrs to get the converted attributes.
+// The attributes returned by Attrs are only valid until Reset is called, so they must be consumed
+// (e.g. copied into a slog.Record with slog.Record.AddAttrs) before the encoder is reset and reused.
+// A FieldEncoder is not safe for concurrent use.
+type FieldEncoder struct {
+	enc slogObjEnc
+}
+
+// NewFieldEncoder returns a new FieldEncoder.  opts may be nil.  Options which
+// affect how fields are converted, like RoundFloat32, are applied.
+func NewFieldEncoder(opts *SlogCoreOptions) *FieldEncoder {
+	if opts == nil {
+		opts = &SlogCoreOptions{}
+	}
+	return &FieldEncoder{enc: slogObjEnc{opts: opts}}
+}
+
+// AddFields encodes the fields.
+func (e *FieldEncoder) AddFields(fields ...zapcore.Field) {
+	for _, f := range fields {
+		e.enc.addField(f)
+	}
+}
+
+// Attrs returns the attributes converted from all the fields added since the last
+// call to Reset.  No more fields should be added after calling Attrs, until Reset is called.
+func (e *FieldEncoder) Attrs() []slog.Attr {
+	return e.enc.finalAttrs()
+}
+
+// Reset clears the encoder so it can be reused, retaining its allocated buffers.
+func (e *FieldEncoder) Reset() {
+	e.enc.reset()
 }
 
 const nAttrsInline = 5
 
+// maxPooledAttrs is the largest attrs buffer which is returned to objEncPool, so
+// an occasional huge entry doesn't pin memory.
+const maxPooledAttrs = 256
+
+var objEncPool = sync.Pool{
+	New: func() any {
+		return &slogObjEnc{}
+	},
+}
+
+// getObjEnc returns an empty encoder from the pool.  The attrs it produces are only valid until it is
+// returned with putObjEnc, and must not be retained, including as the members of a group value.
+func getObjEnc(opts *SlogCoreOptions) *slogObjEnc {
+	s := objEncPool.Get().(*slogObjEnc)
+	s.opts = opts
+	return s
+}
+
+func putObjEnc(s *slogObjEnc) {
+	if cap(s.attrs) > maxPooledAttrs {
+		return
+	}
+	s.reset()
+	s.opts = nil
+	objEncPool.Put(s)
+}
+
 type slogObjEnc struct {
+	opts        *SlogCoreOptions
 	inlineAttrs [nAttrsInline]slog.Attr
 	attrs       []slog.Attr
 	groups      []string
 	groupIdxs   []int
 }
 
+// zapErrorKey is the key of fields created with zap.Error.
+const zapErrorKey = "error"
+
+// addField adds a top-level field, applying ErrorKey and ExtractErrorStacks.
+func (s *slogObjEnc) addField(f zapcore.Field) {
+	if f.Type == zapcore.ErrorType && s.opts != nil {
+		if f.Key == zapErrorKey && s.opts.ErrorKey != "" {
+			f.Key = s.opts.ErrorKey
+		}
+		if err, ok := f.Interface.(error); ok && s.opts.ExtractErrorStacks {
+			if ews, ok := newErrorWithStack(err, s.opts.ErrorStackKey); ok {
+				_ = s.AddObject(f.Key, ews)
+				return
+			}
+		}
+	}
+	f.AddTo(s)
+}
+
 func (s *slogObjEnc) append(attr slog.Attr) {
+	if attr.Key == "" && s.opts != nil && s.opts.DropEmptyKeys && attr.Value.Kind() != slog.KindGroup {
+		return
+	}
 	// avoid allocation if possible
 	if s.attrs == nil {
 		s.attrs = s.inlineAttrs[:0]
@@ -130,17 +897,35 @@ func (s *slogObjEnc) finalAttrs() []slog.Attr {
 	for i := len(s.groups) - 1; i >= 0; i-- {
 		group := s.groups[i]
 		idx := s.groupIdxs[i]
+		if s.opts != nil && s.opts.FlattenNamespaces {
+			for j := idx; j < len(s.attrs); j++ {
+				s.attrs[j].Key = group + "." + s.attrs[j].Key
+			}
+			continue
+		}
 		groupMembers := slices.Clone(s.attrs[idx:])
 		if len(groupMembers) > 0 {
 			s.attrs = append(s.attrs[:idx], slog.Attr{Key: group, Value: slog.GroupValue(groupMembers...)})
 		}
 	}
+	// groups are applied, so make calling this again a no-op
+	s.groups = s.groups[:0]
+	s.groupIdxs = s.groupIdxs[:0]
 
 	return s.attrs
 }
 
+// reset clears the encoder, retaining allocated buffers.
+func (s *slogObjEnc) reset() {
+	// release references to attr values
+	clear(s.attrs[:cap(s.attrs)])
+	s.attrs = s.attrs[:0]
+	s.groups = s.groups[:0]
+	s.groupIdxs = s.groupIdxs[:0]
+}
+
 func (s *slogObjEnc) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
-	senc := sliceArrayEncoder{}
+	senc := sliceArrayEncoder{opts: s.opts}
 	err := marshaler.MarshalLogArray(&senc)
 	if err != nil {
 		return err
@@ -152,14 +937,16 @@ func (s *slogObjEnc) AddArray(key string, marshaler zapcore.ArrayMarshaler) erro
 }
 
 func (s *slogObjEnc) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
-	var s2 slogObjEnc
-	err := marshaler.MarshalLogObject(&s2)
+	s2 := getObjEnc(s.opts)
+	defer putObjEnc(s2)
+	err := marshaler.MarshalLogObject(s2)
 	if err != nil {
 		return err
 	}
 	attrs := s2.finalAttrs()
 	if len(attrs) > 0 {
-		s.append(slog.Any(key, attrs))
+		// the group value is retained, so copy the attrs out of the pooled encoder
+		s.append(slog.Any(key, slices.Clone(attrs)))
 	}
 	return nil
 }
@@ -185,6 +972,10 @@ func (s *slogObjEnc) AddComplex64(key string, value complex64) {
 }
 
 func (s *slogObjEnc) AddDuration(key string, value time.Duration) {
+	if s.opts != nil && s.opts.DurationEncoding != "" {
+		s.append(slog.Attr{Key: key, Value: durationValue(s.opts.DurationEncoding, value)})
+		return
+	}
 	s.append(slog.Duration(key, value))
 }
 
@@ -193,12 +984,17 @@ func (s *slogObjEnc) AddFloat64(key string, value float64) {
 }
 
 func (s *slogObjEnc) AddFloat32(key string, value float32) {
+	if s.opts != nil && s.opts.RoundFloat32 {
+		// round trip through the shortest decimal representation of the float32
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(value), 'g', -1, 32), 64)
+		s.append(slog.Float64(key, f))
+		return
+	}
 	s.append(slog.Float64(key, float64(value)))
 }
 
-// AddInt can't be tested because it's never called.  zap defined this as
-// part of the ObjectEncoder interface, but it's never
-// actually used in zap (AddInt64 is used instead).
+// AddInt isn't used by zap's fields (they use AddInt64), but ObjectMarshalers
+// may call it directly.
 func (s *slogObjEnc) AddInt(key string, value int) {
 	s.append(slog.Int(key, value))
 }
@@ -207,6 +1003,9 @@ func (s *slogObjEnc) AddInt64(key string, value int64) {
 	s.append(slog.Int64(key, value))
 }
 
+// AddInt32 widens the value to a slog.Int64 value, like the other narrow integers are widened to
+// slog.Int64 and slog.Uint64 values.  Their width can't be preserved, since slog.AnyValue also
+// converts int8, int16, etc. to KindInt64 and KindUint64 values.
 func (s *slogObjEnc) AddInt32(key string, value int32) {
 	s.append(slog.Int(key, int(value)))
 }
@@ -227,9 +1026,8 @@ func (s *slogObjEnc) AddTime(key string, value time.Time) {
 	s.append(slog.Time(key, value))
 }
 
-// AddUint can't be tested because it's never called.  zap defined this as
-// part of the ObjectEncoder interface, but it's never
-// actually used in zap (AddUint64 is used instead).
+// AddUint isn't used by zap's fields (they use AddUint64), but ObjectMarshalers
+// may call it directly.
 func (s *slogObjEnc) AddUint(key string, value uint) {
 	s.append(slog.Uint64(key, uint64(value)))
 }
@@ -255,11 +1053,204 @@ func (s *slogObjEnc) AddUintptr(key string, value uintptr) {
 }
 
 func (s *slogObjEnc) AddReflected(key string, value interface{}) error {
+	if lv, ok := value.(slog.LogValuer); ok {
+		s.append(slog.Attr{Key: key, Value: slog.AnyValue(lv).Resolve()})
+		return nil
+	}
+	if s.opts != nil && s.opts.ReflectStructsAsGroups {
+		if v, ok := reflectStruct(value, 0); ok {
+			s.append(slog.Attr{Key: key, Value: v})
+			return nil
+		}
+	}
 	s.append(slog.Any(key, value))
 	return nil
 }
 
+// maxReflectDepth limits how deeply reflectStruct decomposes nested values, which
+// guards against cycles.
+const maxReflectDepth = 10
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// decomposable returns true if the type is a struct which should be decomposed into a group.
+// Structs which have their own encoding, like time.Time, are not.
+func decomposable(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct &&
+		t != timeType &&
+		!t.Implements(textMarshalerType) &&
+		!t.Implements(jsonMarshalerType)
+}
+
+// reflectStruct decomposes a struct, or pointer to a struct, into a group value with
+// an attribute for each exported field.  Returns false if v isn't a struct.
+func reflectStruct(v any, depth int) (slog.Value, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || !decomposable(rv.Type()) || depth >= maxReflectDepth {
+		return slog.Value{}, false
+	}
+
+	fields := structFields(rv)
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		if v, ok := reflectStruct(f.v.Interface(), depth+1); ok {
+			attrs = append(attrs, slog.Attr{Key: f.name, Value: v})
+			continue
+		}
+		attrs = append(attrs, slog.Any(f.name, reflectElems(f.v, depth+1)))
+	}
+	return slog.GroupValue(attrs...), true
+}
+
+// structField is a field of a struct, with its key.
+type structField struct {
+	name  string
+	v     reflect.Value
+	depth int
+}
+
+// structFields returns the fields of the struct rv with their keys, in order, the way
+// encoding/json encodes them.  The fields of embedded structs without a json name are promoted
+// into rv's fields, unless the embedded struct's type is unexported.  If several fields have the same key, the least nested one is kept, and if
+// there is more than one of those, none of them are, like encoding/json does.
+func structFields(rv reflect.Value) []structField {
+	fields := appendStructFields(nil, rv, 0)
+	if len(fields) < 2 {
+		return fields
+	}
+	kept := fields[:0:0]
+	for i, f := range fields {
+		dominant := true
+		for j, other := range fields {
+			if i != j && other.name == f.name && other.depth <= f.depth {
+				dominant = false
+				break
+			}
+		}
+		if dominant {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+func appendStructFields(fields []structField, rv reflect.Value, depth int) []structField {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf, fv := t.Field(i), rv.Field(i)
+		// the fields of embedded structs with unexported types can't be read with reflection, so
+		// they're skipped like other unexported fields
+		if sf.Anonymous && sf.IsExported() && depth < maxReflectDepth {
+			if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name == "" {
+				ev := reflect.Indirect(fv)
+				if fv.Kind() == reflect.Pointer && fv.IsNil() {
+					continue
+				}
+				if ev.Kind() == reflect.Struct {
+					fields = appendStructFields(fields, ev, depth+1)
+					continue
+				}
+			}
+		}
+		if name, ok := jsonFieldName(sf, fv); ok {
+			fields = append(fields, structField{name: name, v: fv, depth: depth})
+		}
+	}
+	return fields
+}
+
+// jsonFieldName returns the key for a struct field, following its json tag the same way
+// encoding/json does, so decomposed structs have the same keys as zap's reflected encoding.
+// structFields promotes the fields of embedded structs.
+// Returns false if the field should be skipped: it's unexported, its tag is "-", or it has
+// the omitempty option and its value v is empty.
+func jsonFieldName(f reflect.StructField, v reflect.Value) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == "omitempty" && isEmptyValue(v) {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// isEmptyValue reports whether v is empty for the json omitempty option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// reflectElems converts slices and arrays into []any, with struct elements
+// decomposed into map[string]any.  Other values are returned as is.
+func reflectElems(rv reflect.Value, depth int) any {
+	if depth >= maxReflectDepth {
+		return rv.Interface()
+	}
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if !rv.IsNil() && decomposable(rv.Elem().Type()) {
+			return reflectElems(rv.Elem(), depth)
+		}
+	case reflect.Struct:
+		if decomposable(rv.Type()) {
+			fields := structFields(rv)
+			m := make(map[string]any, len(fields))
+			for _, f := range fields {
+				m[f.name] = reflectElems(f.v, depth+1)
+			}
+			return m
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			break
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// leave byte slices alone
+			break
+		}
+		elems := make([]any, rv.Len())
+		for i := range elems {
+			elems[i] = reflectElems(rv.Index(i), depth+1)
+		}
+		return elems
+	}
+	return rv.Interface()
+}
+
 func (s *slogObjEnc) OpenNamespace(key string) {
+	// like slog's WithGroup(""), an empty namespace is a no-op, rather than
+	// a group with an empty key, which slog would inline anyway
+	if key == "" {
+		return
+	}
 	// open a new group
 	s.groups = append(s.groups, key)
 	s.groupIdxs = append(s.groupIdxs, len(s.attrs))
@@ -268,24 +1259,131 @@ func (s *slogObjEnc) OpenNamespace(key string) {
 // sliceArrayEncoder implements zapcore.ArrayMarshaler, and marshals the value
 // into a slice of any.
 type sliceArrayEncoder struct {
+	opts  *SlogCoreOptions
 	elems []interface{}
 }
 
 func (s *sliceArrayEncoder) AppendArray(v zapcore.ArrayMarshaler) error {
-	enc := &sliceArrayEncoder{}
+	enc := &sliceArrayEncoder{opts: s.opts}
 	err := v.MarshalLogArray(enc)
 	s.elems = append(s.elems, enc.elems)
 	return err
 }
 
+// arrayElemErrorKey is the key of the error message added to the objects in arrays which
+// failed to marshal, with KeepPartialOnError.
+const arrayElemErrorKey = "error"
+
+// AppendObject encodes the object the same way as an object field, then converts it to an
+// ArrayObject, since slog has no array of groups.  Handlers like slog.JSONHandler render it
+// as a nested object, with the fields in the order they were marshaled.
 func (s *sliceArrayEncoder) AppendObject(v zapcore.ObjectMarshaler) error {
-	m := zapcore.NewMapObjectEncoder()
-	err := v.MarshalLogObject(m)
-	s.elems = append(s.elems, m.Fields)
+	enc := getObjEnc(s.opts)
+	defer putObjEnc(enc)
+	err := v.MarshalLogObject(enc)
+	o := newArrayObject(nil, enc.finalAttrs())
+	if err != nil && s.opts != nil && s.opts.KeepPartialOnError {
+		o = o.set(slog.String(arrayElemErrorKey, err.Error()))
+		err = nil
+	}
+	s.elems = append(s.elems, o)
 	return err
 }
 
+// ArrayObject is an object in an array.  SlogCore converts the objects appended to zap arrays
+// to ArrayObjects, since slog has no array of groups.  It's like a map[string]any, but keeps its
+// members in the order they were added, so it renders the same way every time.  Nested objects
+// are ArrayObjects too.  Handlers can type-assert the array elements to ArrayObject and
+// range over the members, or use zap2slogtest.FindAttrIn.
+type ArrayObject []slog.Attr
+
+// newArrayObject adds the attrs to o, converting groups to nested ArrayObjects.  The
+// members of groups with empty keys are added to o, like slog handlers inline them.  The
+// attrs are copied, so they can be reused after.
+func newArrayObject(o ArrayObject, attrs []slog.Attr) ArrayObject {
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		switch {
+		case v.Kind() != slog.KindGroup:
+			o = o.set(slog.Attr{Key: a.Key, Value: v})
+		case a.Key == "":
+			o = newArrayObject(o, v.Group())
+		default:
+			o = o.set(slog.Any(a.Key, newArrayObject(make(ArrayObject, 0, len(v.Group())), v.Group())))
+		}
+	}
+	return o
+}
+
+// set adds the attr, or replaces the value of the member with the same key, like
+// assigning to a map.
+func (o ArrayObject) set(a slog.Attr) ArrayObject {
+	for i := range o {
+		if o[i].Key == a.Key {
+			o[i].Value = a.Value
+			return o
+		}
+	}
+	return append(o, a)
+}
+
+// MarshalJSON renders the members as a JSON object, in order.
+func (o ArrayObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	// like slog.JSONHandler, don't escape HTML characters
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	encode := func(v any) error {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		// Encode writes a newline after each value
+		buf.Truncate(buf.Len() - 1)
+		return nil
+	}
+	buf.WriteByte('{')
+	for i, a := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encode(a.Key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := encode(a.Value.Any()); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Format formats the members like fmt formats a map, e.g. map[a:1 b:2], but in order.
+func (o ArrayObject) Format(f fmt.State, verb rune) {
+	format := fmt.FormatString(f, verb)
+	_, _ = io.WriteString(f, "map[")
+	for i, a := range o {
+		if i > 0 {
+			_, _ = io.WriteString(f, " ")
+		}
+		_, _ = fmt.Fprintf(f, format+":"+format, a.Key, a.Value.Any())
+	}
+	_, _ = io.WriteString(f, "]")
+}
+
+// duration converts the duration according to DurationEncoding.
+func (s *sliceArrayEncoder) duration(v time.Duration) any {
+	if s.opts != nil && s.opts.DurationEncoding != "" {
+		return durationValue(s.opts.DurationEncoding, v).Any()
+	}
+	return v
+}
+
 func (s *sliceArrayEncoder) AppendReflected(v interface{}) error {
+	if lv, ok := v.(slog.LogValuer); ok {
+		s.elems = append(s.elems, slog.AnyValue(lv).Resolve().Any())
+		return nil
+	}
 	s.elems = append(s.elems, v)
 	return nil
 }
@@ -294,7 +1392,7 @@ func (s *sliceArrayEncoder) AppendBool(v bool)              { s.elems = append(s
 func (s *sliceArrayEncoder) AppendByteString(v []byte)      { s.elems = append(s.elems, string(v)) }
 func (s *sliceArrayEncoder) AppendComplex128(v complex128)  { s.elems = append(s.elems, v) }
 func (s *sliceArrayEncoder) AppendComplex64(v complex64)    { s.elems = append(s.elems, v) }
-func (s *sliceArrayEncoder) AppendDuration(v time.Duration) { s.elems = append(s.elems, v) }
+func (s *sliceArrayEncoder) AppendDuration(v time.Duration) { s.elems = append(s.elems, s.duration(v)) }
 func (s *sliceArrayEncoder) AppendFloat64(v float64)        { s.elems = append(s.elems, v) }
 func (s *sliceArrayEncoder) AppendFloat32(v float32)        { s.elems = append(s.elems, v) }
 func (s *sliceArrayEncoder) AppendInt(v int)                { s.elems = append(s.elems, v) }
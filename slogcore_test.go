@@ -2,17 +2,23 @@ package zap2slog
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ansel1/zap2slog/zap2slogtest"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestSlogCore_Enabled(t *testing.T) {
@@ -31,6 +37,15 @@ func TestSlogCore_Enabled(t *testing.T) {
 	require.False(t, core.Enabled(zapcore.DebugLevel))
 	require.False(t, core.Enabled(zapcore.InfoLevel))
 	require.True(t, core.Enabled(zapcore.WarnLevel))
+
+	// levels below debug map to proportionally lower slog levels
+	lvl.Set(slog.Level(-8))
+	require.True(t, core.Enabled(zapcore.DebugLevel-1))
+	require.False(t, core.Enabled(zapcore.DebugLevel-2))
+
+	var buf strings.Builder
+	NewZapLogger(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: &lvl}), nil).Log(zapcore.DebugLevel-1, "trace")
+	require.Contains(t, buf.String(), "level=DEBUG-4 msg=trace")
 }
 
 func TestSlogCore_Sync(t *testing.T) {
@@ -39,6 +54,59 @@ func TestSlogCore_Sync(t *testing.T) {
 
 	err := core.Sync()
 	require.NoError(t, err)
+
+	t.Run("handler with Sync", func(t *testing.T) {
+		sh := &syncHandler{Handler: h}
+		core := NewSlogCore(sh, nil).With([]zapcore.Field{zap.String("env", "prod")})
+
+		require.NoError(t, core.Sync())
+		require.NoError(t, zap.New(core).Sync())
+		require.Equal(t, 2, sh.syncs)
+
+		sh.err = fmt.Errorf("sync error")
+		require.EqualError(t, core.Sync(), "sync error")
+	})
+
+	t.Run("handler with Flush", func(t *testing.T) {
+		fh := &flushHandler{Handler: h}
+		require.NoError(t, NewSlogCore(fh, nil).Sync())
+		require.Equal(t, 1, fh.flushes)
+	})
+
+	t.Run("SyncFunc", func(t *testing.T) {
+		sh := &syncHandler{Handler: h}
+		var calls int
+		core := NewSlogCore(sh, &SlogCoreOptions{SyncFunc: func() error {
+			calls++
+			return nil
+		}})
+
+		require.NoError(t, core.Sync())
+		require.Equal(t, 1, calls)
+		// SyncFunc takes precedence over the handler's Sync
+		require.Zero(t, sh.syncs)
+	})
+}
+
+type syncHandler struct {
+	slog.Handler
+	syncs int
+	err   error
+}
+
+func (h *syncHandler) Sync() error {
+	h.syncs++
+	return h.err
+}
+
+type flushHandler struct {
+	slog.Handler
+	flushes int
+}
+
+func (h *flushHandler) Flush() error {
+	h.flushes++
+	return nil
 }
 
 func TestSlogCore_Check(t *testing.T) {
@@ -139,7 +207,8 @@ func TestSlogCore_Write(t *testing.T) {
 				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
 				Message: "debug message",
 			},
-			want: "time=2024-01-01T12:00:00.000Z level=DEBUG msg=\"debug message\"\n",
+			// maps to slog.LevelDebug-4, below the handler's level
+			want: "",
 		},
 		{
 			name: "debug level",
@@ -355,7 +424,7 @@ func TestSlogCore_Write(t *testing.T) {
 				`reflect={Name:reflect}`,
 				`strings="[hello world]"`,
 				`dict.size=big dict.color=red`,
-				`dict2.objs="[map[color:red] map[bools:[true false] color:blue]]"`,
+				`dict2.objs="[map[color:red] map[color:blue bools:[true false]]]"`,
 				`nestedarrays="[hello [world]]"`,
 				`inlinekey=inlinevalue`,
 				`complex128=(1+2i)`,
@@ -467,6 +536,240 @@ func TestSlogCore_Write(t *testing.T) {
 			},
 			want: expectedLogLineWithIncompleteSource,
 		},
+		{
+			name: "AddSource option with handler source disabled",
+			opts: &SlogCoreOptions{
+				AddSource: true,
+			},
+			entry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message: "test message",
+				Caller:  zapcore.EntryCaller{Defined: true, PC: pc, File: file, Line: line, Function: "main.run"},
+			},
+			want: fmt.Sprintf("time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" source.function=main.run source.file=%s source.line=%d\n", file, line),
+		},
+		{
+			name: "AddSource option with undefined caller",
+			opts: &SlogCoreOptions{
+				AddSource: true,
+			},
+			entry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message: "test message",
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\"\n",
+		},
+		{
+			name: "float32 widened without rounding",
+			entry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message: "test message",
+			},
+			fields: []zapcore.Field{
+				zap.Float32("float32", 0.1),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" float32=0.10000000149011612\n",
+		},
+		{
+			name: "float32 with RoundFloat32",
+			opts: &SlogCoreOptions{
+				RoundFloat32: true,
+			},
+			entry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message: "test message",
+			},
+			fields: []zapcore.Field{
+				zap.Float32("float32", 0.1),
+				zap.Dict("dict", zap.Float32("nested", 2.2)),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" float32=0.1 dict.nested=2.2\n",
+		},
+		{
+			name: "logger name separator",
+			opts: &SlogCoreOptions{
+				LoggerNameKey:       "logger",
+				LoggerNameSeparator: ".",
+			},
+			entry: zapcore.Entry{
+				Level:      zapcore.InfoLevel,
+				Time:       time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message:    "test message",
+				LoggerName: "a.b.c",
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" logger=\"[a b c]\"\n",
+		},
+		{
+			name: "logger name without separator",
+			opts: &SlogCoreOptions{
+				LoggerNameKey: "logger",
+			},
+			entry: zapcore.Entry{
+				Level:      zapcore.InfoLevel,
+				Time:       time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message:    "test message",
+				LoggerName: "a.b.c",
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" logger=a.b.c\n",
+		},
+		{
+			name: "RequireFields drops message-only entry",
+			opts: &SlogCoreOptions{
+				LoggerNameKey: "logger",
+				RequireFields: true,
+			},
+			entry: zapcore.Entry{
+				Level:      zapcore.InfoLevel,
+				Time:       time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message:    "test message",
+				LoggerName: "mylogger",
+			},
+			fields: []zapcore.Field{
+				zap.Dict("empty"),
+			},
+			want: "",
+		},
+		{
+			name: "RequireFields passes entry with a field",
+			opts: &SlogCoreOptions{
+				RequireFields: true,
+			},
+			entry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message: "test message",
+			},
+			fields: []zapcore.Field{
+				zap.String("user", "alice"),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" user=alice\n",
+		},
+		{
+			name: "logger name keys",
+			opts: &SlogCoreOptions{
+				LoggerNameKeys: []string{"component", "logger"},
+			},
+			entry: zapcore.Entry{
+				Level:      zapcore.InfoLevel,
+				Time:       time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message:    "test message",
+				LoggerName: "mylogger",
+			},
+			fields: []zapcore.Field{
+				zap.String("component", "db"),
+				zap.Namespace("request"),
+				zap.String("logger", "nested"),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" logger=mylogger component=db request.logger=nested\n",
+		},
+		{
+			name: "logger name key with alternate keys",
+			opts: &SlogCoreOptions{
+				LoggerNameKey:  "name",
+				LoggerNameKeys: []string{"component", "logger"},
+			},
+			with: []zapcore.Field{
+				zap.Namespace("request"),
+			},
+			entry: zapcore.Entry{
+				Level:      zapcore.InfoLevel,
+				Time:       time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message:    "test message",
+				LoggerName: "mylogger",
+			},
+			fields: []zapcore.Field{
+				zap.String("name", "nested"),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" name=mylogger request.name=nested\n",
+		},
+		{
+			name: "logger name replaces field with the same key",
+			opts: &SlogCoreOptions{
+				LoggerNameKey: "logger",
+			},
+			with: []zapcore.Field{
+				zap.String("logger", "from-with"),
+			},
+			entry: zapcore.Entry{
+				Level:      zapcore.InfoLevel,
+				Time:       time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message:    "test message",
+				LoggerName: "mylogger",
+			},
+			fields: []zapcore.Field{
+				zap.String("user", "alice"),
+				zap.String("logger", "from-field"),
+				zap.Namespace("request"),
+				zap.String("logger", "nested"),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" logger=mylogger user=alice request.logger=nested\n",
+		},
+		{
+			name: "field with logger name key kept when logger name is blank",
+			opts: &SlogCoreOptions{
+				LoggerNameKey: "logger",
+			},
+			entry: zapcore.Entry{
+				Level:      zapcore.InfoLevel,
+				Time:       time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message:    "test message",
+				LoggerName: "  ",
+			},
+			fields: []zapcore.Field{
+				zap.String("logger", "from-field"),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" logger=from-field\n",
+		},
+		{
+			name:      "short source key",
+			addSource: true,
+			opts: &SlogCoreOptions{
+				ShortSourceKey: "caller",
+			},
+			entry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message: "test message",
+				Caller:  zapcore.EntryCaller{Defined: true, PC: pc},
+			},
+			want: fmt.Sprintf("time=2024-01-01T12:00:00.000Z level=INFO source=%s msg=\"test message\" caller=%s\n", wantSource, zapcore.EntryCaller{Defined: true, File: file, Line: line}.TrimmedPath()),
+		},
+		{
+			name: "empty key kept by default",
+			entry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message: "test message",
+			},
+			fields: []zapcore.Field{
+				zap.String("", "v"),
+				zap.String("color", "red"),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" \"\"=v color=red\n",
+		},
+		{
+			name: "empty key dropped",
+			opts: &SlogCoreOptions{
+				DropEmptyKeys: true,
+			},
+			entry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Message: "test message",
+			},
+			fields: []zapcore.Field{
+				zap.String("", "v"),
+				zap.Int("", 1),
+				zap.Dict("obj", zap.String("", "nested"), zap.String("size", "big")),
+				zap.Dict("", zap.String("inlined", "yes")),
+				zap.String("color", "red"),
+			},
+			want: "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" obj.size=big inlined=yes color=red\n",
+		},
 		{
 			name: "object marshaler error",
 			entry: zapcore.Entry{
@@ -541,11 +844,1594 @@ func BenchmarkSlogCore(b *testing.B) {
 		zap.String("name", "alice"),
 	}
 
-	b.ResetTimer()
-	b.ReportAllocs()
+	b.Run("flat", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ce := core.Check(entry, nil)
+			ce.Write(fields...)
+		}
+	})
+
+	nested := append(slices.Clone(fields),
+		zap.Dict("request", zap.String("path", "/users"), zap.Dict("headers", zap.String("accept", "json"))),
+		zap.Namespace("ns"),
+		zap.String("k", "v"),
+	)
+
+	b.Run("nested", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ce := core.Check(entry, nil)
+			ce.Write(nested...)
+		}
+	})
+}
+
+// retainingHandler keeps every record it handles.
+type retainingHandler struct {
+	records []slog.Record
+}
+
+func (h *retainingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *retainingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *retainingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *retainingHandler) WithGroup(string) slog.Handler { return h }
+
+func TestSlogCore_PooledEncoderNotRetained(t *testing.T) {
+	// records retained by the handler must not be changed by later entries
+	// reusing pooled encoders
+	h := &retainingHandler{}
+	l := NewZapLogger(h, nil)
+	for i := 0; i < 10; i++ {
+		l.Info("msg",
+			zap.Int("i", i),
+			zap.Dict("obj", zap.Int("i", i), zap.Dict("inner", zap.Int("i", i))),
+			zap.Namespace("ns"),
+			zap.Int("j", i),
+		)
+	}
+
+	require.Len(t, h.records, 10)
+	for i, r := range h.records {
+		var buf strings.Builder
+		require.NoError(t, slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}).Handle(context.Background(), r))
+		require.Equal(t, fmt.Sprintf("level=INFO msg=msg i=%[1]d obj.i=%[1]d obj.inner.i=%[1]d ns.j=%[1]d\n", i), buf.String())
+	}
+}
+
+// droppingHandler drops every record in Handle, after Enabled has returned true.
+type droppingHandler struct {
+	slog.Handler
+}
+
+func (droppingHandler) Handle(context.Context, slog.Record) error {
+	return nil
+}
+
+func TestSlogCore_LazyFields(t *testing.T) {
+	entry := zapcore.Entry{
+		Level:   zapcore.InfoLevel,
+		Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Message: "test message",
+	}
+
+	t.Run("fields not encoded when handler drops record", func(t *testing.T) {
+		var calls int
+		obj := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			calls++
+			enc.AddString("color", "red")
+			return nil
+		})
+
+		h := droppingHandler{slog.NewTextHandler(io.Discard, nil)}
+		core := NewSlogCore(h, &SlogCoreOptions{LazyFields: true})
+		require.NoError(t, core.Write(entry, []zapcore.Field{zap.Object("obj", obj)}))
+		require.Zero(t, calls)
+
+		core = NewSlogCore(h, nil)
+		require.NoError(t, core.Write(entry, []zapcore.Field{zap.Object("obj", obj)}))
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("output matches eager encoding", func(t *testing.T) {
+		fields := []zapcore.Field{
+			zap.String("user", "alice"),
+			zap.Namespace("request"),
+			zap.Int("status", 200),
+			zap.Dict("dict", zap.String("size", "big")),
+		}
+
+		var eager, lazy strings.Builder
+		eagerCore := NewSlogCore(slog.NewTextHandler(&eager, nil), &SlogCoreOptions{LoggerNameKey: "logger"}).
+			With([]zapcore.Field{zap.String("env", "prod")})
+		lazyCore := NewSlogCore(slog.NewTextHandler(&lazy, nil), &SlogCoreOptions{LoggerNameKey: "logger", LazyFields: true}).
+			With([]zapcore.Field{zap.String("env", "prod")})
+
+		e := entry
+		e.LoggerName = "mylogger"
+		require.NoError(t, eagerCore.Write(e, fields))
+		require.NoError(t, lazyCore.Write(e, fields))
+
+		require.Equal(t, "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\" logger=mylogger env=prod user=alice request.status=200 request.dict.size=big\n", eager.String())
+		require.Equal(t, eager.String(), lazy.String())
+	})
+
+	t.Run("no fields", func(t *testing.T) {
+		var buf strings.Builder
+		core := NewSlogCore(slog.NewTextHandler(&buf, nil), &SlogCoreOptions{LazyFields: true})
+		require.NoError(t, core.Write(entry, nil))
+		require.Equal(t, "time=2024-01-01T12:00:00.000Z level=INFO msg=\"test message\"\n", buf.String())
+	})
+}
+
+func TestNewZapLogger(t *testing.T) {
+	var buf strings.Builder
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{AddSource: true})
+
+	l := NewZapLogger(h, &SlogCoreOptions{LoggerNameKey: "logger"}, zap.AddCaller())
+	_, file, line, ok := runtime.Caller(0)
+	require.True(t, ok)
+	l.Named("mylogger").Info("hello, world", zap.String("user", "alice"))
+
+	require.Contains(t, buf.String(), fmt.Sprintf(" source=%s:%d ", file, line+2))
+	require.Contains(t, buf.String(), ` msg="hello, world" logger=mylogger user=alice`)
+}
+
+// zapShimInfo is a logging shim, which should be skipped with zap.AddCallerSkip(1).
+//
+//go:noinline
+func zapShimInfo(l *zap.Logger, msg string) {
+	l.Info(msg)
+}
 
-	for i := 0; i < b.N; i++ {
-		ce := core.Check(entry, nil)
-		ce.Write(fields...)
+func TestNewZapLogger_CallerSkip(t *testing.T) {
+	// zap computes the caller, applying the caller skip, before the entry reaches
+	// SlogCore, which forwards the caller's PC to the slog record
+	tests := []struct {
+		name    string
+		opts    *SlogCoreOptions
+		hAddSrc bool
+		wantFmt string
+	}{
+		{name: "slog handler source", hAddSrc: true, wantFmt: "source=%s:%d "},
+		{name: "SlogCore source", opts: &SlogCoreOptions{AddSource: true}, wantFmt: "source.file=%s source.line=%d\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			h := slog.NewTextHandler(&buf, &slog.HandlerOptions{AddSource: tt.hAddSrc})
+
+			l := NewZapLogger(h, tt.opts, zap.AddCaller()).WithOptions(zap.AddCallerSkip(1))
+			_, file, line, ok := runtime.Caller(0)
+			require.True(t, ok)
+			zapShimInfo(l, "hello")
+
+			require.Contains(t, buf.String(), fmt.Sprintf(tt.wantFmt, file, line+2))
+			require.NotContains(t, buf.String(), "zapShimInfo")
+		})
+	}
+}
+
+func TestNewSampledSlogCore(t *testing.T) {
+	var buf strings.Builder
+	h := slog.NewTextHandler(&buf, nil)
+
+	l := zap.New(NewSampledSlogCore(h, nil, time.Minute, 2, 3))
+	for i := 1; i <= 10; i++ {
+		l.Info("hello", zap.Int("i", i))
 	}
+	l.Warn("other")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Contains(t, lines[0], "msg=hello i=1")
+	require.Contains(t, lines[1], "msg=hello i=2")
+	require.Contains(t, lines[2], "msg=hello i=5")
+	require.Contains(t, lines[3], "msg=hello i=8")
+	require.Contains(t, lines[4], "msg=other")
+}
+
+func TestFieldEncoder(t *testing.T) {
+	enc := NewFieldEncoder(nil)
+
+	enc.AddFields(
+		zap.String("user", "alice"),
+		zap.Namespace("request"),
+		zap.Int("status", 200),
+	)
+	attrs := enc.Attrs()
+	require.Equal(t, []slog.Attr{
+		slog.String("user", "alice"),
+		slog.Group("request", slog.Int64("status", 200)),
+	}, attrs)
+	// calling Attrs again doesn't re-apply namespaces
+	require.Equal(t, attrs, enc.Attrs())
+
+	// consume the attrs before resetting
+	rec := slog.NewRecord(time.Time{}, slog.LevelInfo, "first", 0)
+	rec.AddAttrs(attrs...)
+
+	enc.Reset()
+	require.Empty(t, enc.Attrs())
+
+	enc.AddFields(zap.Bool("ok", true))
+	enc.AddFields(zap.Dict("dict", zap.String("size", "big")))
+	require.Equal(t, []slog.Attr{
+		slog.Bool("ok", true),
+		slog.Any("dict", []slog.Attr{slog.String("size", "big")}),
+	}, enc.Attrs())
+
+	// the record's copy of the first entry's attrs is unaffected by reuse
+	var got []slog.Attr
+	rec.Attrs(func(a slog.Attr) bool {
+		got = append(got, a)
+		return true
+	})
+	require.Equal(t, []slog.Attr{
+		slog.String("user", "alice"),
+		slog.Group("request", slog.Int64("status", 200)),
+	}, got)
+
+	enc = NewFieldEncoder(&SlogCoreOptions{RoundFloat32: true})
+	enc.AddFields(zap.Float32("f", 0.1))
+	require.Equal(t, []slog.Attr{slog.Float64("f", 0.1)}, enc.Attrs())
+}
+
+func TestFieldsToAttrs(t *testing.T) {
+	attrs := FieldsToAttrs([]zapcore.Field{
+		zap.String("user", "alice"),
+		zap.Dict("obj", zap.Int("id", 1), zap.Namespace("inner"), zap.Bool("ok", true)),
+		zap.Namespace("request"),
+		zap.Int("status", 200),
+		zap.Namespace("response"),
+		zap.Duration("elapsed", time.Second),
+	})
+	require.Equal(t, []slog.Attr{
+		slog.String("user", "alice"),
+		slog.Group("obj", slog.Int64("id", 1), slog.Group("inner", slog.Bool("ok", true))),
+		slog.Group("request",
+			slog.Int64("status", 200),
+			slog.Group("response", slog.Duration("elapsed", time.Second)),
+		),
+	}, attrs)
+
+	// the attrs aren't shared between calls
+	other := FieldsToAttrs([]zapcore.Field{zap.String("user", "bob")})
+	require.Equal(t, "alice", attrs[0].Value.String())
+	require.Equal(t, "bob", other[0].Value.String())
+
+	require.Empty(t, FieldsToAttrs(nil))
+}
+
+func TestSlogCore_Severity(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  string
+	}{
+		{level: zapcore.DebugLevel, want: "level=DEBUG msg=hello severity=7"},
+		{level: zapcore.InfoLevel, want: "level=INFO msg=hello severity=6"},
+		{level: zapcore.WarnLevel, want: "level=WARN msg=hello severity=4"},
+		{level: zapcore.ErrorLevel, want: "level=ERROR msg=hello severity=3"},
+		{level: zapcore.DPanicLevel, want: "level=ERROR msg=hello severity=2"},
+		{level: zapcore.PanicLevel, want: "level=ERROR msg=hello severity=1"},
+		{level: zapcore.FatalLevel, want: "level=ERROR msg=hello severity=0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			var buf strings.Builder
+			h := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+			core := NewSlogCore(h, &SlogCoreOptions{SeverityKey: "severity"})
+
+			require.NoError(t, core.Write(zapcore.Entry{Level: tt.level, Message: "hello"}, nil))
+			require.Equal(t, tt.want+"\n", buf.String())
+		})
+	}
+
+	t.Run("custom table", func(t *testing.T) {
+		var buf strings.Builder
+		h := slog.NewTextHandler(&buf, nil)
+		core := NewSlogCore(h, &SlogCoreOptions{
+			SeverityKey:    "severity",
+			SeverityLevels: map[zapcore.Level]int{zapcore.WarnLevel: 13},
+		})
+
+		require.NoError(t, core.Write(zapcore.Entry{Level: zapcore.WarnLevel, Message: "hello"}, nil))
+		require.NoError(t, core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "hello"}, nil))
+		require.Equal(t, "level=WARN msg=hello severity=13\nlevel=INFO msg=hello\n", buf.String())
+	})
+}
+
+func TestSlogCore_FieldOrderMatchesZap(t *testing.T) {
+	with := []zapcore.Field{
+		zap.String("env", "prod"),
+		zap.Int("instance", 1),
+	}
+	fields := []zapcore.Field{
+		zap.String("zeta", "z"),
+		zap.Bool("alpha", true),
+		zap.Dict("dict", zap.String("size", "big"), zap.String("color", "red")),
+		zap.Int("middle", 5),
+		zap.Namespace("ns"),
+		zap.String("b", "b"),
+		zap.String("a", "a"),
+	}
+
+	var zapBuf, slogBuf strings.Builder
+	zapCore := zapcore.NewCore(
+		zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"}),
+		zapcore.AddSync(&zapBuf),
+		zapcore.DebugLevel,
+	)
+	zap.New(zapCore).With(with...).Info("hello", fields...)
+
+	NewZapLogger(slog.NewJSONHandler(&slogBuf, nil), nil).With(with...).Info("hello", fields...)
+
+	want := jsonKeys(t, zapBuf.String())
+	got := jsonKeys(t, slogBuf.String())
+	// remove the slog built-in keys
+	got = slices.DeleteFunc(got, func(k string) bool {
+		return k == slog.TimeKey || k == slog.LevelKey
+	})
+
+	require.Equal(t, []string{"msg", "env", "instance", "zeta", "alpha", "dict.size", "dict.color", "middle", "ns.b", "ns.a"}, want)
+	require.Equal(t, want, got)
+}
+
+// jsonKeys returns the keys of a JSON object, in the order they appear.  Nested
+// object keys are prefixed with their parent keys.
+func jsonKeys(t *testing.T, s string) []string {
+	t.Helper()
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	tok, err := dec.Token()
+	require.NoError(t, err)
+	require.Equal(t, json.Delim('{'), tok)
+
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		require.NoError(t, err)
+		key := tok.(string)
+
+		var v json.RawMessage
+		require.NoError(t, dec.Decode(&v))
+		if strings.HasPrefix(string(v), "{") {
+			for _, k := range jsonKeys(t, string(v)) {
+				keys = append(keys, key+"."+k)
+			}
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestSlogCore_ReplaceAttrByLogger(t *testing.T) {
+	redact := func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == "card" {
+			return slog.String(a.Key, "REDACTED")
+		}
+		if a.Key == "cvv" {
+			return slog.Attr{}
+		}
+		return a
+	}
+	upper := func(groups []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() == slog.KindString {
+			return slog.String(a.Key, strings.ToUpper(a.Value.String()))
+		}
+		return a
+	}
+
+	for _, lazy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazy=%v", lazy), func(t *testing.T) {
+			var buf strings.Builder
+			h := slog.NewTextHandler(&buf, nil)
+			l := NewZapLogger(h, &SlogCoreOptions{
+				LoggerNameKey:       "logger",
+				LazyFields:          lazy,
+				ReplaceAttr:         upper,
+				ReplaceAttrByLogger: map[string]func([]string, slog.Attr) slog.Attr{"payments": redact},
+			})
+
+			fields := []zapcore.Field{
+				zap.String("card", "visa"),
+				zap.Dict("details", zap.String("cvv", "abc"), zap.String("card", "amex")),
+			}
+			l.Named("payments").Info("charge", fields...)
+			l.Named("orders").Info("charge", fields...)
+			l.Info("charge", fields...)
+
+			require.Equal(t, strings.Join([]string{
+				`msg=charge logger=payments card=REDACTED details.card=REDACTED`,
+				`msg=charge logger=ORDERS card=VISA details.cvv=ABC details.card=AMEX`,
+				`msg=charge card=VISA details.cvv=ABC details.card=AMEX`,
+			}, "\n"), stripTimeAndLevel(buf.String()))
+		})
+	}
+}
+
+// stripTimeAndLevel removes the time and level from text handler log lines, and
+// trims the trailing newline.
+func stripTimeAndLevel(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		fields := strings.SplitN(line, " ", 3)
+		lines[i] = fields[len(fields)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestSlogCore_FlushOnLevel(t *testing.T) {
+	var syncs int
+	l := NewZapLogger(slog.NewTextHandler(io.Discard, nil), &SlogCoreOptions{
+		FlushOnLevel: slog.LevelError,
+		SyncFunc: func() error {
+			syncs++
+			return nil
+		},
+	})
+
+	l.Info("info")
+	l.Warn("warn")
+	require.Zero(t, syncs)
+
+	l.Error("error")
+	require.Equal(t, 1, syncs)
+
+	l.DPanic("dpanic")
+	require.Equal(t, 2, syncs)
+
+	// sync errors are returned from Write
+	core := NewSlogCore(slog.NewTextHandler(io.Discard, nil), &SlogCoreOptions{
+		FlushOnLevel: slog.LevelWarn,
+		SyncFunc: func() error {
+			return fmt.Errorf("sync error")
+		},
+	})
+	require.EqualError(t, core.Write(zapcore.Entry{Level: zapcore.WarnLevel}, nil), "sync error")
+	require.NoError(t, core.Write(zapcore.Entry{Level: zapcore.InfoLevel}, nil))
+}
+
+// userValuer implements slog.LogValuer.
+type userValuer struct {
+	name     string
+	password string
+}
+
+func (u userValuer) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("name", u.name))
+}
+
+func TestSlogCore_ReflectedLogValuer(t *testing.T) {
+	var buf strings.Builder
+	l := NewZapLogger(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}), nil)
+
+	u := userValuer{name: "alice", password: "secret"}
+	l.Info("hello",
+		zap.Reflect("user", u),
+		zap.Any("valuer", logValuerFunc(func() slog.Value { return slog.StringValue("resolved") })),
+		zap.Dict("dict", zap.Reflect("user", u)),
+		zap.Array("arr", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+			return enc.AppendReflected(logValuerFunc(func() slog.Value { return slog.IntValue(42) }))
+		})),
+	)
+
+	require.JSONEq(t, `{"level":"INFO","msg":"hello","user":{"name":"alice"},"valuer":"resolved","dict":{"user":{"name":"alice"}},"arr":[42]}`, buf.String())
+	require.NotContains(t, buf.String(), "secret")
+}
+
+type reflectAddress struct {
+	City string
+	zip  string
+}
+
+type reflectPerson struct {
+	Name    string
+	Age     int
+	Home    reflectAddress
+	Work    *reflectAddress
+	Tags    []string
+	Past    []reflectAddress
+	Born    time.Time
+	private string
+}
+
+type reflectTagged struct {
+	Name     string            `json:"name"`
+	Password string            `json:"-"`
+	Dash     string            `json:"-,"`
+	Nick     string            `json:",omitempty"`
+	Email    string            `json:"email,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Friends  []reflectTagged   `json:"friends,omitempty"`
+	Extra    map[string]string `json:"extra,omitempty"`
+}
+
+func TestSlogCore_ReflectStructsAsGroups_JSONTags(t *testing.T) {
+	u := reflectTagged{
+		Name:     "bob",
+		Password: "hunter2",
+		Dash:     "d",
+		Email:    "bob@example.com",
+		Friends:  []reflectTagged{{Name: "carol", Password: "hunter3"}},
+	}
+
+	attrs := encodeFields([]zapcore.Field{zap.Reflect("user", u)}, &SlogCoreOptions{ReflectStructsAsGroups: true}, nil)
+
+	// the same keys as zap's default reflected encoding
+	want, err := json.Marshal(u)
+	require.NoError(t, err)
+	var buf strings.Builder
+	NewZapLogger(slog.NewJSONHandler(&buf, nil), &SlogCoreOptions{ReflectStructsAsGroups: true}).Info("hi", zap.Reflect("user", u))
+	require.NotContains(t, buf.String(), "hunter")
+	var got struct{ User json.RawMessage }
+	require.NoError(t, json.Unmarshal([]byte(buf.String()), &got))
+	require.JSONEq(t, string(want), string(got.User))
+
+	require.Equal(t, []slog.Attr{slog.Group("user",
+		slog.String("name", "bob"),
+		slog.String("-", "d"),
+		slog.String("email", "bob@example.com"),
+		slog.Any("friends", []any{map[string]any{"name": "carol", "-": ""}}),
+	)}, attrs)
+}
+
+type ReflectBase struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ReflectAudit struct {
+	Name string `json:"name"`
+	By   string `json:"by"`
+}
+
+type reflectEmbedding struct {
+	ReflectBase
+	*ReflectAudit
+	Name    string             `json:"name"`
+	Kind    string             `json:"kind"`
+	Friends []reflectEmbedding `json:"friends,omitempty"`
+}
+
+func TestSlogCore_ReflectStructsAsGroups_Embedded(t *testing.T) {
+	// fields of embedded structs are promoted, and shadowed by less nested fields
+	u := reflectEmbedding{
+		ReflectBase:  ReflectBase{ID: 7, Name: "base"},
+		ReflectAudit: &ReflectAudit{By: "root"},
+		Name:         "alice",
+		Kind:         "user",
+		Friends:      []reflectEmbedding{{Name: "bob"}},
+	}
+	attrs := encodeFields([]zapcore.Field{zap.Reflect("user", u)}, &SlogCoreOptions{ReflectStructsAsGroups: true}, nil)
+	require.Equal(t, []slog.Attr{slog.Group("user",
+		slog.Int64("id", 7),
+		slog.String("by", "root"),
+		slog.String("name", "alice"),
+		slog.String("kind", "user"),
+		slog.Any("friends", []any{map[string]any{"id": 0, "name": "bob", "kind": ""}}),
+	)}, attrs)
+
+	// the same keys as zap's default reflected encoding
+	want, err := json.Marshal(u)
+	require.NoError(t, err)
+	var buf strings.Builder
+	NewZapLogger(slog.NewJSONHandler(&buf, nil), &SlogCoreOptions{ReflectStructsAsGroups: true}).Info("hi", zap.Reflect("user", u))
+	var got struct{ User json.RawMessage }
+	require.NoError(t, json.Unmarshal([]byte(buf.String()), &got))
+	require.JSONEq(t, string(want), string(got.User))
+}
+
+func TestSlogCore_ReflectStructsAsGroups(t *testing.T) {
+	p := reflectPerson{
+		Name:    "alice",
+		Age:     30,
+		Home:    reflectAddress{City: "Denver", zip: "80202"},
+		Tags:    []string{"a", "b"},
+		Past:    []reflectAddress{{City: "Austin"}},
+		Born:    time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC),
+		private: "secret",
+	}
+
+	newLogger := func(buf *strings.Builder, reflectStructs bool) *zap.Logger {
+		return NewZapLogger(slog.NewJSONHandler(buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}), &SlogCoreOptions{ReflectStructsAsGroups: reflectStructs})
+	}
+
+	var buf strings.Builder
+	newLogger(&buf, true).Info("hi", zap.Reflect("person", p), zap.Reflect("ptr", &p.Home), zap.Reflect("num", 5))
+	require.JSONEq(t, `{
+		"level":"INFO","msg":"hi",
+		"person":{
+			"Name":"alice","Age":30,
+			"Home":{"City":"Denver"},
+			"Work":null,
+			"Tags":["a","b"],
+			"Past":[{"City":"Austin"}],
+			"Born":"1990-01-02T00:00:00Z"
+		},
+		"ptr":{"City":"Denver"},
+		"num":5
+	}`, buf.String())
+	require.NotContains(t, buf.String(), "secret")
+	require.NotContains(t, buf.String(), "80202")
+
+	// structs become real slog groups, not opaque values
+	rh := zap2slogtest.NewRecordingHandler(nil)
+	NewZapLogger(rh, &SlogCoreOptions{ReflectStructsAsGroups: true}).
+		Info("hi", zap.Reflect("person", reflectPerson{Home: reflectAddress{City: "Denver"}}))
+	r, ok := rh.Last()
+	require.True(t, ok)
+	city, ok := zap2slogtest.FindAttr(r, "person", "Home", "City")
+	require.True(t, ok)
+	require.Equal(t, "Denver", city.Value.String())
+
+	// disabled by default
+	buf.Reset()
+	newLogger(&buf, false).Info("hi", zap.Reflect("ptr", &p.Home))
+	require.JSONEq(t, `{"level":"INFO","msg":"hi","ptr":{"City":"Denver"}}`, buf.String())
+
+	rh.Reset()
+	NewZapLogger(rh, nil).Info("hi", zap.Reflect("home", p.Home))
+	r, ok = rh.Last()
+	require.True(t, ok)
+	home, ok := zap2slogtest.FindAttr(r, "home")
+	require.True(t, ok)
+	require.Equal(t, slog.KindAny, home.Value.Kind())
+}
+
+func TestSlogCore_ConcurrentWith(t *testing.T) {
+	// run with -race.  Children derived concurrently from the same parent must
+	// not share a backing array for their fields.
+	var buf syncBuilder
+	parent := NewSlogCore(slog.NewJSONHandler(&buf, nil), nil).
+		With([]zapcore.Field{zap.String("parent", "p")})
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			child := parent.With([]zapcore.Field{zap.Int("child", i)})
+			if err := child.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "msg"}, []zapcore.Field{zap.Int("i", i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, n)
+	for _, line := range lines {
+		var m map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		require.Equal(t, "p", m["parent"])
+		require.Equal(t, m["i"], m["child"], line)
+	}
+}
+
+func TestSlogCore_WithNamespace(t *testing.T) {
+	// zap nests all fields after a namespace in it, including fields added
+	// with later calls to With, and fields added at the log site
+	zapWant := func(build func(*zap.Logger) *zap.Logger) string {
+		var buf strings.Builder
+		enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+		l := build(zap.New(zapcore.NewCore(enc, zapcore.AddSync(&buf), zapcore.DebugLevel)))
+		l.Info("hi", zap.String("field", "v"))
+		return buf.String()
+	}
+
+	tests := []struct {
+		name  string
+		build func(*zap.Logger) *zap.Logger
+		want  string
+	}{
+		{
+			name: "namespace",
+			build: func(l *zap.Logger) *zap.Logger {
+				return l.With(zap.Namespace("a"))
+			},
+			want: "msg=hi logger=n a.field=v\n",
+		},
+		{
+			name: "namespace then with",
+			build: func(l *zap.Logger) *zap.Logger {
+				return l.With(zap.String("before", "x"), zap.Namespace("a")).With(zap.String("b", "y"))
+			},
+			want: "msg=hi logger=n before=x a.b=y a.field=v\n",
+		},
+		{
+			name: "nested namespaces",
+			build: func(l *zap.Logger) *zap.Logger {
+				return l.With(zap.Namespace("a")).With(zap.Namespace("b"))
+			},
+			want: "msg=hi logger=n a.b.field=v\n",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, lazy := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s lazy=%v", tt.name, lazy), func(t *testing.T) {
+				var buf strings.Builder
+				h := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+					ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+						if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+							return slog.Attr{}
+						}
+						return a
+					},
+				})
+				l := tt.build(NewZapLogger(h, &SlogCoreOptions{LoggerNameKey: "logger", LazyFields: lazy}).Named("n"))
+				l.Info("hi", zap.String("field", "v"))
+				require.Equal(t, tt.want, buf.String())
+
+				// same structure as zap's own encoder
+				var slogJSON strings.Builder
+				tt.build(NewZapLogger(slog.NewJSONHandler(&slogJSON, &slog.HandlerOptions{
+					ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+						if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+							return slog.Attr{}
+						}
+						return a
+					},
+				}), &SlogCoreOptions{LazyFields: lazy})).Info("hi", zap.String("field", "v"))
+				require.JSONEq(t, zapWant(tt.build), slogJSON.String())
+			})
+		}
+	}
+}
+
+func TestSlogCore_FlattenNamespaces(t *testing.T) {
+	fields := []zapcore.Field{
+		zap.String("top", "t"),
+		zap.Namespace("request"),
+		zap.String("method", "GET"),
+		zap.Dict("headers", zap.String("accept", "json")),
+		zap.Namespace("user"),
+		zap.Int("id", 7),
+	}
+
+	tests := []struct {
+		name    string
+		flatten bool
+		want    string
+	}{
+		{
+			name: "nested",
+			want: `{"msg":"hi","top":"t","request":{"method":"GET","headers":{"accept":"json"},"user":{"id":7}}}`,
+		},
+		{
+			name:    "flattened",
+			flatten: true,
+			want:    `{"msg":"hi","top":"t","request.method":"GET","request.headers":{"accept":"json"},"request.user.id":7}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+						return slog.Attr{}
+					}
+					return a
+				},
+			})
+			l := NewZapLogger(h, &SlogCoreOptions{FlattenNamespaces: tt.flatten})
+			l.Info("hi", fields...)
+			require.JSONEq(t, tt.want, buf.String())
+
+			// same with the namespaces opened by With
+			buf.Reset()
+			l.With(fields[:2]...).Info("hi", fields[2:]...)
+			require.JSONEq(t, tt.want, buf.String())
+		})
+	}
+}
+
+// point is an ObjectMarshaler with a nested object and namespace.
+type point struct {
+	X, Y float32
+}
+
+func (p point) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddFloat32("x", p.X)
+	_ = enc.AddObject("meta", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("", "dropped")
+		enc.AddString("unit", "px")
+		return nil
+	}))
+	enc.OpenNamespace("ns")
+	enc.AddFloat32("y", p.Y)
+	return nil
+}
+
+func TestSlogCore_ArrayOfObjects(t *testing.T) {
+	var buf strings.Builder
+	h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	l := NewZapLogger(h, &SlogCoreOptions{RoundFloat32: true, DropEmptyKeys: true})
+
+	l.Info("hi",
+		zap.Objects("points", []point{{X: 1.1, Y: 2.2}, {X: 3.3, Y: 4.4}}),
+		zap.Array("nested", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+			return enc.AppendArray(zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+				return enc.AppendObject(point{X: 5.5, Y: 6.6})
+			}))
+		})),
+	)
+
+	// the options, like RoundFloat32 and DropEmptyKeys, apply to the objects' fields too
+	require.JSONEq(t, `{
+		"msg":"hi",
+		"points":[
+			{"x":1.1,"meta":{"unit":"px"},"ns":{"y":2.2}},
+			{"x":3.3,"meta":{"unit":"px"},"ns":{"y":4.4}}
+		],
+		"nested":[[{"x":5.5,"meta":{"unit":"px"},"ns":{"y":6.6}}]]
+	}`, buf.String())
+}
+
+func TestSlogCore_CompactGroups(t *testing.T) {
+	var buf strings.Builder
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	l := NewZapLogger(h, &SlogCoreOptions{CompactGroups: true})
+
+	l.Info("hi",
+		zap.String("top", "t"),
+		zap.Dict("req",
+			zap.String("method", "GET"),
+			zap.Dict("user", zap.Int("id", 7), zap.String("name", "alice smith")),
+			zap.Dict("", zap.Bool("inlined", true)),
+		),
+		zap.Dict("", zap.Dict("inner", zap.Int("a", 1))),
+		zap.Namespace("ns"),
+		zap.String("k", ""),
+	)
+	require.Equal(t, `msg=hi top=t req="{method=GET user={id=7 name=\"alice smith\"} inlined=true}" inner="{a=1}" ns="{k=\"\"}"`+"\n", buf.String())
+}
+
+func TestSlogCore_ErrorKey(t *testing.T) {
+	newLogger := func(buf *strings.Builder, opts *SlogCoreOptions) *zap.Logger {
+		return NewZapLogger(slog.NewTextHandler(buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}), opts)
+	}
+	err := errors.New("boom")
+
+	tests := []struct {
+		name string
+		opts *SlogCoreOptions
+		want string
+	}{
+		{
+			name: "default",
+			want: "msg=hi error=boom cause=boom\n",
+		},
+		{
+			name: "renamed",
+			opts: &SlogCoreOptions{ErrorKey: "err"},
+			want: "msg=hi err=boom cause=boom\n",
+		},
+		{
+			name: "renamed with ReplaceAttr",
+			opts: &SlogCoreOptions{
+				ErrorKey: "err",
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == "err" {
+						return slog.String(a.Key, strings.ToUpper(a.Value.String()))
+					}
+					return a
+				},
+			},
+			want: "msg=hi err=BOOM cause=boom\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			newLogger(&buf, tt.opts).Info("hi", zap.Error(err), zap.NamedError("cause", err))
+			require.Equal(t, tt.want, buf.String())
+
+			// also applies to fields added with With
+			buf.Reset()
+			newLogger(&buf, tt.opts).With(zap.Error(err)).Info("hi", zap.NamedError("cause", err))
+			require.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+// failingObject adds a field, then fails to marshal.
+type failingObject struct{}
+
+func (failingObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("partial", "yes")
+	return errors.New("marshal failed")
+}
+
+func TestSlogCore_KeepPartialOnError(t *testing.T) {
+	objs := []zapcore.ObjectMarshaler{
+		zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddInt("id", 1)
+			return nil
+		}),
+		failingObject{},
+	}
+
+	tests := []struct {
+		name string
+		keep bool
+		want string
+	}{
+		{
+			name: "dropped",
+			want: `{"msg":"hi","objsError":"marshal failed","after":"a"}`,
+		},
+		{
+			name: "kept",
+			keep: true,
+			want: `{"msg":"hi","objs":[{"id":1},{"partial":"yes","error":"marshal failed"}],"after":"a"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+						return slog.Attr{}
+					}
+					return a
+				},
+			})
+			NewZapLogger(h, &SlogCoreOptions{KeepPartialOnError: tt.keep}).
+				Info("hi", zap.Objects("objs", objs), zap.String("after", "a"))
+			require.JSONEq(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestSlogCore_DiagnosticHandler(t *testing.T) {
+	var out, diag strings.Builder
+	diagHandler := slog.NewTextHandler(&diag, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+
+	l := NewZapLogger(slog.NewTextHandler(&out, nil), &SlogCoreOptions{DiagnosticHandler: diagHandler})
+
+	// unnamed loggers don't warn
+	l.Info("unnamed")
+	require.Empty(t, diag.String())
+
+	l.Named("db").Info("first")
+	l.Named("db").Info("second")
+	l.With(zap.String("a", "b")).Named("http").Info("third")
+	require.Equal(t, `level=WARN msg="zap2slog: dropping logger names, because SlogCoreOptions.LoggerNameKey is not set" loggerName=db`+"\n", diag.String())
+	require.Len(t, strings.Split(strings.TrimSpace(out.String()), "\n"), 4)
+
+	// no warning if the key is set
+	diag.Reset()
+	NewZapLogger(slog.NewTextHandler(&out, nil), &SlogCoreOptions{LoggerNameKey: "logger", DiagnosticHandler: diagHandler}).Named("db").Info("named")
+	require.Empty(t, diag.String())
+
+	// or if the logger name is used as a group
+	NewZapLogger(slog.NewTextHandler(&out, nil), &SlogCoreOptions{LoggerNameAsGroup: true, DiagnosticHandler: diagHandler}).Named("db").Info("named", zap.Int("a", 1))
+	require.Empty(t, diag.String())
+
+	// the warnings are timestamped with the Clock
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	rh := zap2slogtest.NewRecordingHandler(nil)
+	NewZapLogger(slog.NewTextHandler(&out, nil), &SlogCoreOptions{DiagnosticHandler: rh, Clock: clock}).Named("db").Info("named")
+	r, ok := rh.Last()
+	require.True(t, ok)
+	require.Equal(t, clock.Now(), r.Time)
+}
+
+// slowHandler advances a fake clock while handling each record.
+type slowHandler struct {
+	slog.Handler
+	clock *fakeClock
+	delay time.Duration
+}
+
+func (h *slowHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.clock.Advance(h.delay)
+	return h.Handler.Handle(ctx, r)
+}
+
+func TestSlogCore_OnSlowHandle(t *testing.T) {
+	type slow struct {
+		msg     string
+		elapsed time.Duration
+	}
+	var slows []slow
+	onSlow := func(r slog.Record, elapsed time.Duration) {
+		slows = append(slows, slow{msg: r.Message, elapsed: elapsed})
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	h := &slowHandler{Handler: slog.NewTextHandler(io.Discard, nil), clock: clock, delay: 20 * time.Millisecond}
+	l := NewZapLogger(h, &SlogCoreOptions{OnSlowHandle: onSlow, SlowHandleThreshold: 10 * time.Millisecond, Clock: clock})
+	l.Info("slow")
+	require.Equal(t, []slow{{msg: "slow", elapsed: 20 * time.Millisecond}}, slows)
+
+	// fast handlers are under the threshold
+	slows = nil
+	h.delay = 5 * time.Millisecond
+	l.Info("fast")
+	require.Empty(t, slows)
+}
+
+func TestSlogCore_ReplaceLoggerName(t *testing.T) {
+	var gotGroups []string
+	replace := func(groups []string, a slog.Attr) slog.Attr {
+		switch {
+		case a.Key == "logger" && a.Value.String() == "secret":
+			return slog.Attr{}
+		case a.Key == "logger" && a.Value.String() == "lazy":
+			return slog.Any(a.Key, logValuerFunc(func() slog.Value { return slog.StringValue("resolved") }))
+		case a.Key == "logger":
+			gotGroups = groups
+			return slog.String(a.Key, strings.ToUpper(a.Value.String()))
+		}
+		return a
+	}
+
+	var buf strings.Builder
+	logger := NewZapLogger(slog.NewTextHandler(&buf, nil), &SlogCoreOptions{
+		LoggerNameKey: "logger",
+		ReplaceAttr:   replace,
+	})
+
+	logger.Named("db").Info("hi")
+	require.Contains(t, buf.String(), "msg=hi logger=DB\n")
+	require.Empty(t, gotGroups)
+
+	// the logger name isn't nested in namespaces
+	buf.Reset()
+	logger.Named("db").With(zap.Namespace("ns")).Info("hi", zap.String("k", "v"))
+	require.Contains(t, buf.String(), "msg=hi logger=DB ns.k=v\n")
+	require.Empty(t, gotGroups)
+
+	// an empty attribute drops the logger name
+	buf.Reset()
+	logger.Named("secret").Info("hi")
+	require.NotContains(t, buf.String(), "logger=")
+
+	// LogValuers returned by ReplaceAttr are resolved, like for other attributes
+	rh := zap2slogtest.NewRecordingHandler(nil)
+	NewZapLogger(rh, &SlogCoreOptions{LoggerNameKey: "logger", ReplaceAttr: replace}).Named("lazy").Info("hi")
+	r, _ := rh.Last()
+	a, ok := zap2slogtest.FindAttr(r, "logger")
+	require.True(t, ok)
+	require.Equal(t, slog.KindString, a.Value.Kind())
+	require.Equal(t, "resolved", a.Value.String())
+}
+
+func TestSlogCore_LoggerNameAsGroup(t *testing.T) {
+	var buf strings.Builder
+	newLogger := func(opts *SlogCoreOptions) *zap.Logger {
+		buf.Reset()
+		return NewZapLogger(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}), opts)
+	}
+
+	newLogger(&SlogCoreOptions{LoggerNameAsGroup: true, LoggerNameKey: "logger"}).
+		Named("db").Named("pool").With(zap.Int("size", 5)).Info("hi", zap.String("conn", "a"))
+	require.JSONEq(t, `{"level":"INFO","msg":"hi","logger":"db.pool","db.pool":{"size":5,"conn":"a"}}`, buf.String())
+
+	newLogger(&SlogCoreOptions{LoggerNameAsGroup: true, LoggerNameSeparator: "."}).
+		Named("db").Named("pool").Info("hi", zap.String("conn", "a"))
+	require.JSONEq(t, `{"level":"INFO","msg":"hi","db":{"pool":{"conn":"a"}}}`, buf.String())
+
+	// unnamed loggers and entries without fields aren't grouped
+	l := newLogger(&SlogCoreOptions{LoggerNameAsGroup: true})
+	l.Info("hi", zap.String("conn", "a"))
+	require.JSONEq(t, `{"level":"INFO","msg":"hi","conn":"a"}`, buf.String())
+	buf.Reset()
+	l.Named("db").Info("hi")
+	require.JSONEq(t, `{"level":"INFO","msg":"hi"}`, buf.String())
+}
+
+func TestSlogCore_LoggerNameAsGroup_RetainedRecords(t *testing.T) {
+	// the group's members must outlive the pooled encoder they were converted with
+	h := zap2slogtest.NewRecordingHandler(nil)
+	l := NewZapLogger(h, &SlogCoreOptions{LoggerNameAsGroup: true})
+	l.Named("db").Info("first", zap.String("a", "b"), zap.Int("n", 1))
+	l.Named("db").Info("second", zap.String("c", "d"), zap.Int("n", 2))
+
+	records := h.Records()
+	require.Len(t, records, 2)
+	a, ok := zap2slogtest.FindAttr(records[0], "db", "a")
+	require.True(t, ok)
+	require.Equal(t, "b", a.Value.String())
+	n, ok := zap2slogtest.FindAttr(records[0], "db", "n")
+	require.True(t, ok)
+	require.Equal(t, int64(1), n.Value.Int64())
+}
+
+func TestSlogCore_NamedError(t *testing.T) {
+	// zap.NamedError is distinct from zap.Error: it keeps its own key, and isn't
+	// renamed by ErrorKey
+	h := zap2slogtest.NewRecordingHandler(nil)
+	NewZapLogger(h, &SlogCoreOptions{ErrorKey: "err"}).
+		Info("hi", zap.NamedError("cause", errors.New("boom")), zap.Dict("obj", zap.NamedError("inner", errors.New("bang"))))
+
+	r, ok := h.Last()
+	require.True(t, ok)
+	cause, ok := zap2slogtest.FindAttr(r, "cause")
+	require.True(t, ok)
+	require.Equal(t, "boom", cause.Value.String())
+	inner, ok := zap2slogtest.FindAttr(r, "obj", "inner")
+	require.True(t, ok)
+	require.Equal(t, "bang", inner.Value.String())
+	_, ok = zap2slogtest.FindAttr(r, "err")
+	require.False(t, ok)
+	_, ok = zap2slogtest.FindAttr(r, "error")
+	require.False(t, ok)
+
+	// a nil error is skipped, like zap.NamedError does for all encoders
+	h.Reset()
+	NewZapLogger(h, nil).Info("hi", zap.NamedError("cause", nil))
+	r, _ = h.Last()
+	require.Equal(t, 0, r.NumAttrs())
+}
+
+func TestSlogCore_TimestampKey(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		opts *SlogCoreOptions
+		time time.Time
+		want string
+	}{
+		{
+			name: "basic format",
+			opts: &SlogCoreOptions{TimestampKey: "ts"},
+			time: ts,
+			want: "20240101T120000Z",
+		},
+		{
+			name: "basic format with offset",
+			opts: &SlogCoreOptions{TimestampKey: "ts"},
+			time: ts.In(time.FixedZone("", -5*60*60)),
+			want: "20240101T070000-0500",
+		},
+		{
+			name: "custom layout",
+			opts: &SlogCoreOptions{TimestampKey: "ts", TimestampLayout: time.Kitchen},
+			time: ts,
+			want: "12:00PM",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := zap2slogtest.NewRecordingHandler(nil)
+			core := NewSlogCore(h, tt.opts)
+			require.NoError(t, core.Write(zapcore.Entry{Time: tt.time, Message: "hi"}, nil))
+
+			r, ok := h.Last()
+			require.True(t, ok)
+			require.True(t, r.Time.Equal(ts))
+			a, ok := zap2slogtest.FindAttr(r, "ts")
+			require.True(t, ok)
+			require.Equal(t, tt.want, a.Value.String())
+		})
+	}
+}
+
+func TestSlogCore_MarshalerAddIntAndUint(t *testing.T) {
+	// zap's fields never call AddInt or AddUint, but third-party marshalers do
+	obj := zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddInt("int", -1)
+		enc.AddUint("uint", 2)
+		return nil
+	})
+	arr := zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+		enc.AppendInt(-1)
+		enc.AppendUint(2)
+		return nil
+	})
+
+	attrs := FieldsToAttrs([]zapcore.Field{zap.Object("obj", obj), zap.Array("arr", arr)})
+	require.Equal(t, []slog.Attr{
+		slog.Group("obj", slog.Int("int", -1), slog.Uint64("uint", 2)),
+		slog.Any("arr", []any{-1, uint(2)}),
+	}, attrs)
+
+	group := attrs[0].Value.Group()
+	require.Equal(t, slog.KindInt64, group[0].Value.Kind())
+	require.Equal(t, slog.KindUint64, group[1].Value.Kind())
+}
+
+func TestSlogCore_InlineNamespace(t *testing.T) {
+	// an inline marshaler's fields merge into the current level.  A namespace it opens stays
+	// open for the following fields, until the end of the enclosing object, like zap's encoders.
+	inline := zap.Inline(dictObject{zap.String("b", "2"), zap.Namespace("ns"), zap.String("c", "3")})
+	fields := []zapcore.Field{
+		zap.String("a", "1"),
+		inline,
+		zap.String("d", "4"),
+		zap.Dict("obj", inline, zap.String("e", "5")),
+	}
+
+	var zapBuf strings.Builder
+	zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{}), zapcore.AddSync(&zapBuf), zapcore.InfoLevel)).Info("", fields...)
+	require.JSONEq(t, `{"a":"1","b":"2","ns":{"c":"3","d":"4","obj":{"b":"2","ns":{"c":"3","e":"5"}}}}`, zapBuf.String())
+
+	for _, lazy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lazy=%v", lazy), func(t *testing.T) {
+			var buf strings.Builder
+			NewZapLogger(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.MessageKey) {
+						return slog.Attr{}
+					}
+					return a
+				},
+			}), &SlogCoreOptions{LazyFields: lazy}).Info("", fields...)
+			require.JSONEq(t, zapBuf.String(), buf.String())
+		})
+	}
+}
+
+func TestSlogCore_LevelLabels(t *testing.T) {
+	var buf strings.Builder
+	l := NewZapLogger(slog.NewTextHandler(&buf, nil), &SlogCoreOptions{
+		LevelLabels: map[zapcore.Level]string{zapcore.WarnLevel: "warning", zapcore.InfoLevel: "info"},
+	})
+
+	// the default key doesn't duplicate the handler's level
+	l.Warn("hi", zap.String("k", "v"))
+	require.Contains(t, buf.String(), "level=WARN msg=hi level_label=warning k=v\n")
+
+	buf.Reset()
+	l.Error("hi")
+	require.Contains(t, buf.String(), "level=ERROR msg=hi\n")
+
+	// the label can replace the handler's level
+	buf.Reset()
+	h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// drop the handler's own time and level, but not the label
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			if _, ok := a.Value.Any().(slog.Level); ok && len(groups) == 0 && a.Key == slog.LevelKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	NewZapLogger(h, &SlogCoreOptions{
+		LevelLabels:   map[zapcore.Level]string{zapcore.WarnLevel: "warning"},
+		LevelLabelKey: slog.LevelKey,
+	}).Warn("hi", zap.String("k", "v"))
+	require.JSONEq(t, `{"level":"warning","msg":"hi","k":"v"}`, buf.String())
+}
+
+func TestSlogCore_EmptyNamespace(t *testing.T) {
+	h := zap2slogtest.NewRecordingHandler(nil)
+	NewZapLogger(h, nil).With(zap.Namespace("")).Info("hi",
+		zap.String("a", "1"),
+		zap.Dict("obj", zap.Namespace(""), zap.String("b", "2")),
+		zap.Namespace(""),
+		zap.String("c", "3"),
+	)
+
+	r, ok := h.Last()
+	require.True(t, ok)
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	require.Equal(t, []slog.Attr{
+		slog.String("a", "1"),
+		slog.Group("obj", slog.String("b", "2")),
+		slog.String("c", "3"),
+	}, attrs)
+}
+
+func TestSlogCore_Skip(t *testing.T) {
+	arr := zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+		return enc.AppendObject(dictObject{zap.Skip(), zap.Int("id", 1)})
+	})
+	fields := []zapcore.Field{
+		zap.Skip(),
+		zap.Dict("dict", zap.Skip(), zap.String("a", "b")),
+		zap.Dict("skipped", zap.Skip()),
+		zap.Array("arr", arr),
+		zap.Error(nil), // also a skip field
+	}
+
+	require.Equal(t, []slog.Attr{
+		slog.Group("dict", slog.String("a", "b")),
+		slog.Any("arr", []any{ArrayObject{slog.Int64("id", 1)}}),
+	}, FieldsToAttrs(fields))
+
+	var buf strings.Builder
+	NewZapLogger(slog.NewTextHandler(&buf, nil), nil).Info("hi", fields...)
+	require.Contains(t, buf.String(), "msg=hi dict.a=b arr=[map[id:1]]\n")
+}
+
+// namespacedObject opens a namespace before its last field.
+type namespacedObject struct {
+	id int
+}
+
+func (o namespacedObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("id", o.id)
+	enc.OpenNamespace("meta")
+	enc.AddString("kind", "test")
+	enc.OpenNamespace("deeper")
+	enc.AddBool("ok", true)
+	return nil
+}
+
+func TestSlogCore_ArrayObjectNamespaces(t *testing.T) {
+	field := zap.Objects("items", []namespacedObject{{id: 1}, {id: 2}})
+
+	var zapBuf strings.Builder
+	zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{}), zapcore.AddSync(&zapBuf), zapcore.InfoLevel)).Info("", field)
+	want := `{"items":[
+		{"id":1,"meta":{"kind":"test","deeper":{"ok":true}}},
+		{"id":2,"meta":{"kind":"test","deeper":{"ok":true}}}
+	]}`
+	require.JSONEq(t, want, zapBuf.String())
+
+	var buf strings.Builder
+	NewZapLogger(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.MessageKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}), nil).Info("", field)
+	require.JSONEq(t, want, buf.String())
+
+	// FlattenNamespaces applies to array elements too
+	require.Equal(t, []slog.Attr{slog.Any("items", []any{
+		ArrayObject{slog.Int64("id", 1), slog.String("meta.kind", "test"), slog.Bool("meta.deeper.ok", true)},
+	})}, encodeFields([]zapcore.Field{zap.Objects("items", []namespacedObject{{id: 1}})}, &SlogCoreOptions{FlattenNamespaces: true}, nil))
+}
+
+func TestSlogCore_OnWriteAndOnDrop(t *testing.T) {
+	var written, dropped []zapcore.Entry
+	l := NewZapLogger(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}), &SlogCoreOptions{
+		OnWrite: func(e zapcore.Entry) { written = append(written, e) },
+		OnDrop:  func(e zapcore.Entry) { dropped = append(dropped, e) },
+	})
+
+	l.Debug("debug")
+	l.Info("info")
+	l.Named("db").Warn("warn")
+
+	require.Len(t, written, 2)
+	require.Equal(t, zapcore.InfoLevel, written[0].Level)
+	require.Equal(t, "info", written[0].Message)
+	require.Equal(t, zapcore.WarnLevel, written[1].Level)
+	require.Equal(t, "db", written[1].LoggerName)
+
+	// zap.Logger checks Enabled first, and doesn't call Check for levels no core enables
+	require.Empty(t, dropped)
+
+	// Check is called with the whole entry
+	core := l.Core()
+	require.Nil(t, core.Check(zapcore.Entry{Level: zapcore.DebugLevel, Message: "checked"}, nil))
+	require.Equal(t, []zapcore.Entry{{Level: zapcore.DebugLevel, Message: "checked"}}, dropped)
+
+	// asking for the level isn't a drop
+	dropped = nil
+	require.True(t, core.Enabled(zapcore.InfoLevel))
+	require.False(t, core.Enabled(zapcore.DebugLevel))
+	require.Equal(t, zapcore.InfoLevel, l.Level())
+	require.Equal(t, zapcore.InfoLevel, zapcore.LevelOf(core))
+	require.Empty(t, dropped)
+
+	// behind a tee, an entry only the SlogCore drops is counted once
+	other, logs := observer.New(zapcore.DebugLevel)
+	zap.New(zapcore.NewTee(core, other)).Debug("teed")
+	require.Equal(t, 1, logs.Len())
+	require.Len(t, dropped, 1)
+	require.Equal(t, "teed", dropped[0].Message)
+
+	// nothing is enabled
+	none := NewSlogCore(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}), nil)
+	require.Equal(t, zapcore.InvalidLevel, none.Level())
+	require.Equal(t, zapcore.WarnLevel, NewSlogCoreWithLevel(slog.NewTextHandler(io.Discard, nil), zapcore.WarnLevel, nil).Level())
+
+	// entries suppressed by CoalesceWindow or RequireFields aren't written
+	written = nil
+	l = NewZapLogger(slog.NewTextHandler(io.Discard, nil), &SlogCoreOptions{
+		CoalesceWindow: time.Hour,
+		RequireFields:  true,
+		OnWrite:        func(e zapcore.Entry) { written = append(written, e) },
+	})
+	l.Info("no fields")
+	l.Info("hi", zap.Int("a", 1))
+	l.Info("hi", zap.Int("a", 1))
+	require.Len(t, written, 1)
+	require.NoError(t, l.Sync())
+	require.Len(t, written, 2)
+}
+
+func TestSlogCore_DurationEncoding(t *testing.T) {
+	d := 1500 * time.Millisecond
+	arr := zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+		enc.AppendDuration(d)
+		return nil
+	})
+	tests := []struct {
+		encoding DurationEncoding
+		want     slog.Value
+		wantText string
+	}{
+		{"", slog.DurationValue(d), "d=1.5s arr=[1.5s]"},
+		{DurationString, slog.DurationValue(d), "d=1.5s arr=[1.5s]"},
+		{DurationSeconds, slog.Float64Value(1.5), "d=1.5 arr=[1.5]"},
+		{DurationMillis, slog.Float64Value(1500), "d=1500 arr=[1500]"},
+		{DurationNanos, slog.Int64Value(1500000000), "d=1500000000 arr=[1500000000]"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.encoding), func(t *testing.T) {
+			opts := &SlogCoreOptions{DurationEncoding: tt.encoding}
+			attrs := encodeFields([]zapcore.Field{zap.Duration("d", d), zap.Array("arr", arr)}, opts, nil)
+			require.True(t, tt.want.Equal(attrs[0].Value), "got %v", attrs[0].Value)
+			require.Equal(t, tt.want.Any(), attrs[1].Value.Any().([]any)[0])
+
+			var buf strings.Builder
+			NewZapLogger(slog.NewTextHandler(&buf, nil), opts).Info("hi", zap.Duration("d", d), zap.Array("arr", arr))
+			require.Contains(t, buf.String(), "msg=hi "+tt.wantText+"\n")
+		})
+	}
+}
+
+func TestZapToSlogLevel(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  slog.Level
+	}{
+		{zapcore.Level(-3), slog.LevelDebug - 8},
+		{zapcore.Level(-2), slog.LevelDebug - 4},
+		{zapcore.DebugLevel, slog.LevelDebug},
+		{zapcore.InfoLevel, slog.LevelInfo},
+		{zapcore.WarnLevel, slog.LevelWarn},
+		{zapcore.ErrorLevel, slog.LevelError},
+		{zapcore.DPanicLevel, slog.LevelError},
+		{zapcore.PanicLevel, slog.LevelError},
+		{zapcore.FatalLevel, slog.LevelError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			require.Equal(t, tt.want, ZapToSlogLevel(tt.level))
+		})
+	}
+}
+
+func TestSlogCore_ArrayObjectOrder(t *testing.T) {
+	arr := zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+		return enc.AppendObject(dictObject{
+			zap.String("zebra", "z"),
+			zap.Int("apple", 1),
+			zap.Dict("mango", zap.Bool("yes", true), zap.String("html", "<b>")),
+			zap.String("kiwi", "k"),
+			zap.Int("apple", 2), // replaces the first apple, in place
+		})
+	})
+
+	require.Equal(t, []slog.Attr{slog.Any("arr", []any{ArrayObject{
+		slog.String("zebra", "z"),
+		slog.Int64("apple", 2),
+		slog.Any("mango", ArrayObject{slog.Bool("yes", true), slog.String("html", "<b>")}),
+		slog.String("kiwi", "k"),
+	}})}, FieldsToAttrs([]zapcore.Field{zap.Array("arr", arr)}))
+
+	b, err := ArrayObject{slog.String("a", "<x>"), slog.Any("b", ArrayObject{slog.Int64("c", 1)})}.MarshalJSON()
+	require.NoError(t, err)
+	require.Equal(t, `{"a":"<x>","b":{"c":1}}`, string(b))
+
+	// the order is the same every time, not randomized like a map's
+	for i := 0; i < 20; i++ {
+		var buf strings.Builder
+		NewZapLogger(slog.NewJSONHandler(&buf, nil), nil).Info("hi", zap.Array("arr", arr))
+		require.Contains(t, buf.String(), `"arr":[{"zebra":"z","apple":2,"mango":{"yes":true,"html":"<b>"},"kiwi":"k"}]`)
+
+		buf.Reset()
+		NewZapLogger(slog.NewTextHandler(&buf, nil), nil).Info("hi", zap.Array("arr", arr))
+		require.Contains(t, buf.String(), `arr="[map[zebra:z apple:2 mango:map[yes:true html:<b>] kiwi:k]]"`)
+	}
+}
+
+func TestNewSlogCoreWithLevel(t *testing.T) {
+	var buf strings.Builder
+	// the handler's level is fixed at debug
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	lvl := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	l := zap.New(NewSlogCoreWithLevel(h, lvl, nil)).With(zap.String("k", "v"))
+
+	l.Debug("debug")
+	require.Empty(t, buf.String())
+	l.Info("info")
+	require.Contains(t, buf.String(), "msg=info k=v\n")
+
+	// changing the level at runtime applies to cores derived with With too
+	buf.Reset()
+	lvl.SetLevel(zapcore.DebugLevel)
+	l.Debug("debug")
+	require.Contains(t, buf.String(), "msg=debug k=v\n")
+
+	buf.Reset()
+	lvl.SetLevel(zapcore.ErrorLevel)
+	l.Warn("warn")
+	require.Empty(t, buf.String())
+
+	// the handler still has to be enabled too
+	buf.Reset()
+	lvl.SetLevel(zapcore.DebugLevel)
+	zap.New(NewSlogCoreWithLevel(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}), lvl, nil)).Info("info")
+	require.Empty(t, buf.String())
+
+	// a nil LevelEnabler only consults the handler
+	zap.New(NewSlogCoreWithLevel(h, nil, nil)).Debug("debug")
+	require.Contains(t, buf.String(), "msg=debug\n")
 }
@@ -0,0 +1,60 @@
+package zap2slog
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+)
+
+// teeHandler sends records to several handlers.
+type teeHandler []slog.Handler
+
+// NewTeeHandler returns a slog.Handler which sends records to all the handlers, e.g. to a ZapHandler
+// and to another slog.Handler.  It's enabled for a level if any of the handlers is, and each record
+// is only passed to the handlers enabled for its level.  Each handler gets its own clone of the
+// record.  Errors from the handlers are joined.
+func NewTeeHandler(handlers ...slog.Handler) slog.Handler {
+	return teeHandler(slices.Clone(handlers))
+}
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			if err := h.Handle(ctx, r.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	t2 := make(teeHandler, len(t))
+	for i, h := range t {
+		// handlers may retain the slice
+		t2[i] = h.WithAttrs(slices.Clone(attrs))
+	}
+	return t2
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return t
+	}
+	t2 := make(teeHandler, len(t))
+	for i, h := range t {
+		t2[i] = h.WithGroup(name)
+	}
+	return t2
+}
@@ -0,0 +1,68 @@
+package zap2slog
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/ansel1/zap2slog/zap2slogtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewTeeHandler(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	rec := zap2slogtest.NewRecordingHandler(slog.LevelDebug)
+	l := slog.New(NewTeeHandler(NewZapHandler(core, nil), rec))
+
+	assert.True(t, l.Enabled(context.Background(), slog.LevelDebug))
+	assert.False(t, l.Enabled(context.Background(), slog.LevelDebug-1))
+
+	// only the recording handler is enabled for debug
+	l.Debug("debug")
+	assert.Zero(t, logs.Len())
+	require.Len(t, rec.Records(), 1)
+	rec.Reset()
+
+	l.With("service", "api").WithGroup("req").WithGroup("").Info("hello", "id", 1)
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "hello", entries[0].Message)
+	assert.Equal(t, []zapcore.Field{
+		zap.String("service", "api"),
+		zap.Any("req", []zapcore.Field{zap.Int64("id", 1)}),
+	}, entries[0].Context)
+
+	r, ok := rec.Last()
+	require.True(t, ok)
+	assert.Equal(t, "hello", r.Message)
+	assert.Equal(t, entries[0].Time, r.Time)
+	a, ok := zap2slogtest.FindAttr(r, "req", "id")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), a.Value.Int64())
+	_, ok = zap2slogtest.FindAttr(r, "service")
+	assert.True(t, ok)
+}
+
+type errHandler struct {
+	slog.Handler
+	err error
+}
+
+func (h errHandler) Handle(context.Context, slog.Record) error { return h.err }
+
+func TestNewTeeHandler_Errors(t *testing.T) {
+	rec := zap2slogtest.NewRecordingHandler(nil)
+	h := NewTeeHandler(errHandler{Handler: rec, err: errors.New("first")}, rec, errHandler{Handler: rec, err: errors.New("second")})
+
+	err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0))
+	assert.EqualError(t, err, "first\nsecond")
+	// the handlers after a failing handler still get the record
+	assert.Len(t, rec.Records(), 1)
+}
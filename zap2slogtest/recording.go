@@ -0,0 +1,176 @@
+// Package zap2slogtest provides helpers for testing code which logs through
+// zap2slog.
+package zap2slogtest
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// RecordingHandler is a slog.Handler which captures a clone of every record
+// it handles, so tests can assert on the time, level, message, and attrs
+// directly instead of parsing formatted output.
+//
+// Attrs and groups added with WithAttrs and WithGroup are folded into the
+// captured records, nested the same way a slog.JSONHandler would nest them.
+// Handlers derived from a RecordingHandler record into the same list.
+//
+// A RecordingHandler is safe for concurrent use.
+type RecordingHandler struct {
+	level   slog.Leveler
+	rec     *recorder
+	attrs   []slog.Attr
+	groups  []string
+	offsets []int // index in attrs at which each group was opened
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+// NewRecordingHandler returns a RecordingHandler which records entries at or
+// above level.  If level is nil, all entries are recorded.
+func NewRecordingHandler(level slog.Leveler) *RecordingHandler {
+	return &RecordingHandler{level: level, rec: &recorder{}}
+}
+
+// Enabled implements slog.Handler.
+func (h *RecordingHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.level == nil || level >= h.level.Level()
+}
+
+// Handle implements slog.Handler.
+func (h *RecordingHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := slices.Clone(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	// fold the open groups, innermost first.  Empty groups are dropped,
+	// as slog's built-in handlers do.
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		off := h.offsets[i]
+		members := slices.Clone(attrs[off:])
+		attrs = attrs[:off]
+		if len(members) > 0 {
+			attrs = append(attrs, slog.Attr{Key: h.groups[i], Value: slog.GroupValue(members...)})
+		}
+	}
+
+	nr := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	nr.AddAttrs(attrs...)
+
+	h.rec.mu.Lock()
+	defer h.rec.mu.Unlock()
+	h.rec.records = append(h.rec.records, nr)
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *RecordingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.attrs = append(slices.Clip(h.attrs), attrs...)
+	return &h2
+}
+
+// WithGroup implements slog.Handler.
+func (h *RecordingHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(slices.Clip(h.groups), name)
+	h2.offsets = append(slices.Clip(h.offsets), len(h.attrs))
+	return &h2
+}
+
+// Records returns a copy of the records captured so far, in the order they
+// were handled.
+func (h *RecordingHandler) Records() []slog.Record {
+	h.rec.mu.Lock()
+	defer h.rec.mu.Unlock()
+	records := make([]slog.Record, len(h.rec.records))
+	for i, r := range h.rec.records {
+		records[i] = r.Clone()
+	}
+	return records
+}
+
+// Last returns the most recently captured record.  It returns false if no
+// records have been captured.
+func (h *RecordingHandler) Last() (slog.Record, bool) {
+	h.rec.mu.Lock()
+	defer h.rec.mu.Unlock()
+	if len(h.rec.records) == 0 {
+		return slog.Record{}, false
+	}
+	return h.rec.records[len(h.rec.records)-1].Clone(), true
+}
+
+// Reset discards all captured records.
+func (h *RecordingHandler) Reset() {
+	h.rec.mu.Lock()
+	defer h.rec.mu.Unlock()
+	h.rec.records = nil
+}
+
+// FindAttr returns the attr at path in r.  Each element of path but the last
+// names a group to descend into.  LogValuers are resolved along the way.  If
+// a key occurs more than once in the same group, the last occurrence wins,
+// matching how most JSON decoders would see it.
+func FindAttr(r slog.Record, path ...string) (slog.Attr, bool) {
+	if len(path) == 0 {
+		return slog.Attr{}, false
+	}
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return findAttr(attrs, path)
+}
+
+// FindAttrIn is like FindAttr, but searches a list of attrs, such as the
+// members of a group.
+func FindAttrIn(attrs []slog.Attr, path ...string) (slog.Attr, bool) {
+	if len(path) == 0 {
+		return slog.Attr{}, false
+	}
+	return findAttr(attrs, path)
+}
+
+func findAttr(attrs []slog.Attr, path []string) (slog.Attr, bool) {
+	var found slog.Attr
+	ok := false
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Key == "" && a.Value.Kind() == slog.KindGroup {
+			// inlined group
+			if inner, iok := findAttr(a.Value.Group(), path); iok {
+				found, ok = inner, true
+			}
+			continue
+		}
+		if a.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			found, ok = a, true
+			continue
+		}
+		if a.Value.Kind() != slog.KindGroup {
+			continue
+		}
+		if inner, iok := findAttr(a.Value.Group(), path[1:]); iok {
+			found, ok = inner, true
+		}
+	}
+	return found, ok
+}
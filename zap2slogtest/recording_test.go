@@ -0,0 +1,114 @@
+package zap2slogtest
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingHandler(t *testing.T) {
+	h := NewRecordingHandler(slog.LevelInfo)
+	l := slog.New(h)
+
+	l.Debug("dropped")
+	l.With("service", "api").WithGroup("req").With("id", 1).WithGroup("empty").Info("hello", "a", "b")
+
+	records := h.Records()
+	require.Len(t, records, 1)
+	r := records[0]
+	assert.Equal(t, slog.LevelInfo, r.Level)
+	assert.Equal(t, "hello", r.Message)
+
+	a, ok := FindAttr(r, "service")
+	require.True(t, ok)
+	assert.Equal(t, "api", a.Value.String())
+
+	a, ok = FindAttr(r, "req", "id")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), a.Value.Int64())
+
+	a, ok = FindAttr(r, "req", "empty", "a")
+	require.True(t, ok)
+	assert.Equal(t, "b", a.Value.String())
+
+	_, ok = FindAttr(r, "req", "missing")
+	assert.False(t, ok)
+	_, ok = FindAttr(r, "service", "x")
+	assert.False(t, ok)
+	_, ok = FindAttr(r)
+	assert.False(t, ok)
+
+	last, ok := h.Last()
+	require.True(t, ok)
+	assert.Equal(t, r.Message, last.Message)
+
+	h.Reset()
+	assert.Empty(t, h.Records())
+	_, ok = h.Last()
+	assert.False(t, ok)
+}
+
+func TestRecordingHandler_EmptyGroupsDropped(t *testing.T) {
+	h := NewRecordingHandler(nil)
+	slog.New(h).With("a", 1).WithGroup("g").Info("msg")
+
+	r, ok := h.Last()
+	require.True(t, ok)
+	assert.Equal(t, 1, r.NumAttrs())
+	_, ok = FindAttr(r, "g")
+	assert.False(t, ok)
+}
+
+func TestRecordingHandler_Clones(t *testing.T) {
+	h := NewRecordingHandler(nil)
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+	r.AddAttrs(slog.Int("a", 1), slog.Int("b", 2), slog.Int("c", 3), slog.Int("d", 4), slog.Int("e", 5))
+	require.NoError(t, h.Handle(context.Background(), r))
+
+	// mutating the handled record, or a returned record, must not change
+	// what was captured
+	r.AddAttrs(slog.Int("f", 6))
+	got := h.Records()[0]
+	got.AddAttrs(slog.Int("g", 7))
+	assert.Equal(t, 5, h.Records()[0].NumAttrs())
+}
+
+func TestFindAttrIn(t *testing.T) {
+	attrs := []slog.Attr{
+		slog.Group("", slog.String("inlined", "x")),
+		slog.Any("valuer", slog.GroupValue(slog.Int("n", 1))),
+		slog.String("dup", "first"),
+		slog.String("dup", "second"),
+	}
+	a, ok := FindAttrIn(attrs, "inlined")
+	require.True(t, ok)
+	assert.Equal(t, "x", a.Value.String())
+
+	a, ok = FindAttrIn(attrs, "valuer", "n")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), a.Value.Int64())
+
+	a, ok = FindAttrIn(attrs, "dup")
+	require.True(t, ok)
+	assert.Equal(t, "second", a.Value.String())
+}
+
+func TestRecordingHandler_Concurrent(t *testing.T) {
+	h := NewRecordingHandler(nil)
+	l := slog.New(h)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.With("i", i).Info("msg")
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, h.Records(), 10)
+}
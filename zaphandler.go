@@ -1,10 +1,19 @@
 package zap2slog
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"net"
+	"reflect"
 	"runtime"
 	"slices"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -15,11 +24,155 @@ type ZapHandlerOptions struct {
 	AddSource bool
 	// ReplaceAttr allows for customizing the attributes of the slog.Record before they are written to the zap log entry.
 	// For more information. see slog.HandlerOptions.ReplaceAttr.
+	//
+	// Like the slog built-in handlers, ReplaceAttr is also called with the record's time, level, message,
+	// and source (if AddSource is set), and the results are used to set the zap entry's time, level,
+	// message, and caller.
 	ReplaceAttr func(groups []string, a slog.Attr) slog.Attr
 	// LoggerNameKey will search the slog.Record for an attribute with this key.  If found, the zap
 	// entry's logger name will be set to the value of that attribute, and the attribute will be elided
 	// from the zap entry's fields.
 	LoggerNameKey string
+	// MessageKey will search the slog.Record's attributes for one with this key.  If found, the zap
+	// entry's message will be set to the attribute's string value instead of the record's message,
+	// and the attribute will be elided.  Like LoggerNameKey, only attributes which aren't in a group
+	// are matched.  Unlike LoggerNameKey, attributes added with WithAttrs are not matched.
+	MessageKey string
+	// AfterEncode, if set, is called with the record's level and the final zap fields, after all
+	// attributes have been converted and groups have been applied, just before the entry is written.
+	// The fields, including the members of groups, are a copy, so modifying them won't affect the
+	// entry or the handler.
+	AfterEncode func(level slog.Level, fields []zapcore.Field)
+	// RequireFields drops slog records which have no attributes, after the attributes have been
+	// converted to zap fields.  Attributes which were elided, or used as the logger name, don't count.
+	RequireFields bool
+	// ReplaceAttrByLogger maps logger names to ReplaceAttr functions.  If the handler's logger name,
+	// set by an attribute with LoggerNameKey passed to WithAttrs, is in the map, that function is used
+	// instead of ReplaceAttr.
+	ReplaceAttrByLogger map[string]func(groups []string, a slog.Attr) slog.Attr
+	// CoalesceWindow, if positive, coalesces consecutive identical records.  The first record is
+	// written, and identical records following it within the window are suppressed.  When a different
+	// record is handled or the window expires, the last suppressed record is written with a RepeatedKey
+	// field containing the number of suppressed records.  Records are identical if they have the same
+	// level, logger name, message, and attributes, after the attributes have been converted to zap fields.
+	CoalesceWindow time.Duration
+	// MapsAsObjects converts attribute values which are maps to zap object fields, with
+	// one field per map entry, sorted by key, so zap encoders write them as nested objects.  Non-string
+	// keys are converted to strings.  Without this, maps are passed to zap.Any, which usually
+	// reflects them.  Maps nested deeper than MaxGroupDepth, or DefaultMaxGroupDepth if there is
+	// no limit, are passed to zap.Any too, so maps which contain themselves don't recurse forever.
+	MapsAsObjects bool
+	// OnFatal is called after a record with a level at or above FatalLevel has been written.  Since
+	// slog levels don't map to zap's DPanic, Panic, or Fatal levels, the zap core won't panic or exit
+	// the process.  OnFatal can be used to restore those side effects, e.g. by calling os.Exit(1).
+	// OnFatal is only called if both OnFatal and FatalLevel are set.  It isn't called for records which
+	// weren't written: records suppressed by CoalesceWindow, or records which a handler created with
+	// NewZapHandlerDirect failed to write.
+	OnFatal func(record slog.Record)
+	// FatalLevel is the minimum level which triggers OnFatal.
+	FatalLevel slog.Leveler
+	// ShortSourceKey, if set along with AddSource, adds a field with this key containing the caller's
+	// trimmed path and line, like "pkg/file.go:12", in addition to the entry's caller.  This lets the
+	// entry's caller have the full path, while the field is easier to read.
+	ShortSourceKey string
+	// MaxLogValuerDepth, if positive, limits how many times LogValue is called when resolving a chain
+	// of slog.LogValuers, bounding the time spent resolving.  If the value is still a LogValuer after
+	// that many calls, it is replaced with a string describing the problem.  Otherwise, values are
+	// resolved with slog.Value.Resolve, which has its own, larger limit.
+	MaxLogValuerDepth int
+	// MessageHashKey, if set, adds a field with this key containing a hash of the record's
+	// message, with numbers and UUIDs masked.  See MessageHash.
+	MessageHashKey string
+	// CallerSkip, if positive, reports the caller that many frames above the record's PC, for
+	// handlers wrapped by logging shims, where the record's PC points into the shim.  Like zap's
+	// AddCallerSkip, 1 skips one frame.  The frames are found in the stack of the goroutine calling
+	// Handle, so if the record is handled on another goroutine, the record's PC is used as is.
+	CallerSkip int
+	// Clock, if set, provides the time for records with a zero time.  Use SystemClock for the
+	// current time.  Otherwise, the zero time is passed to the zap core.
+	Clock Clock
+	// SourcePrecedence decides which source is used when AddSource is set, and a record has both a PC
+	// and a top-level source attribute, e.g. one added by a wrapping handler.  See SourcePrecedence.
+	SourcePrecedence SourcePrecedence
+	// StrictEnabled makes Enabled check an entry with the core's Check, instead of calling the core's
+	// Enabled, which may be less strict, e.g. for cores which filter entries by logger name in Check.
+	// The entry only has a level and the handler's logger name.
+	//
+	// StrictEnabled is incompatible with cores which keep state in Check, like the samplers from
+	// zapcore.NewSamplerWithOptions: every call to Enabled counts as an entry with an empty message,
+	// so the sampler's budget is used up and Enabled starts returning false for every record.
+	StrictEnabled bool
+	// SourceAsField, if set along with AddSource, adds the source as a field with slog's source keys
+	// (function, file, and line), nested in the handler's open groups, instead of setting the entry's
+	// caller.  ShortSourceKey is ignored.
+	SourceAsField bool
+	// CacheCallerFrames caches the frames resolved from records' PCs when AddSource is set, in a
+	// bounded LRU cache shared by the handler and the handlers derived from it.  This saves resolving
+	// the same PC again, which can show up in profiles when logging at high volume.
+	CacheCallerFrames bool
+	// ExtractErrorStacks converts error attribute values which record a stack trace, like errors
+	// from github.com/pkg/errors, to an object field with the error's message under "msg" and the
+	// stack's frames under ErrorStackKey.  Errors without a stack trace are converted as usual.
+	ExtractErrorStacks bool
+	// ErrorStackKey is the key of the stack frames added by ExtractErrorStacks.  If empty,
+	// DefaultErrorStackKey is used.
+	ErrorStackKey string
+	// SkipOnContextDone skips writing records if the context passed to Handle is done, and returns
+	// the context's error instead.  This avoids work during shutdown, when the caller's context has
+	// been cancelled.
+	SkipOnContextDone bool
+	// DropKeyPrefixes elides attributes whose full key starts with one of these prefixes.  The full key
+	// is the attribute's key prefixed with its groups, including the handler's groups, joined with
+	// ".", like "req.internal.id".  Attributes are dropped before ReplaceAttr is called, and groups
+	// left empty are elided.
+	DropKeyPrefixes []string
+	// LinearLevels maps slog levels to zap levels linearly, instead of clamping them to zap's debug
+	// through error levels.  Each zap level is 4 slog levels, so the built-in levels map as usual, and
+	// other levels map to zap level ceil(level/4): slog.Level(-8) is zapcore.Level(-2), and
+	// slog.LevelError+4 is zapcore.DPanicLevel, which the core writes like any other level (a core
+	// doesn't panic or exit; the zap.Logger does).  Levels between the built-in levels round up,
+	// e.g. slog.LevelWarn+1 is zapcore.ErrorLevel.  Levels beyond the range of zapcore.Level are
+	// clamped.  Use this with encoders which render custom zap levels.
+	LinearLevels bool
+	// DedupeKeys drops fields whose key is repeated later in the same group, so the last one wins,
+	// e.g. when a record attribute has the same key as an attribute added with WithAttrs.  Fields
+	// with the same key in different groups are kept.  The fields added by ContextAttrs,
+	// ShortSourceKey and MessageHashKey are deduped too.
+	DedupeKeys bool
+	// ContextAttrs, if set, is called with the context passed to Handle, and returns attributes to
+	// add to the entry, e.g. trace and span IDs.  They're added at the top level, after the handler's
+	// groups are applied, so they aren't nested in any groups.  They don't count for RequireFields.
+	ContextAttrs func(context.Context) []slog.Attr
+	// MaxGroupDepth limits how deeply groups are nested, counting the handler's groups, to bound the
+	// recursion when converting deeply nested groups.  Groups nested deeper are flattened into the
+	// group at the limit, with their keys joined with ".", like "a.b.c".  If zero,
+	// DefaultMaxGroupDepth is used.  If negative, there is no limit, so groups which contain
+	// themselves, e.g. through a LogValuer, recurse until the stack overflows.
+	MaxGroupDepth int
+}
+
+// SourcePrecedence decides which of a record's PC and source attribute is used as a zap entry's caller.
+type SourcePrecedence int
+
+const (
+	// SourcePrecedenceBoth uses the record's PC as the caller, and keeps the source attribute
+	// as a field.  This is the default.
+	SourcePrecedenceBoth SourcePrecedence = iota
+	// SourcePrecedencePC uses the record's PC as the caller, and drops the source attribute.  The
+	// source attribute is only used if the record has no PC.
+	SourcePrecedencePC
+	// SourcePrecedenceAttr uses the source attribute as the caller, and ignores the record's PC.  The
+	// PC is only used if the record has no source attribute.  The attribute's value must be a
+	// *slog.Source or slog.Source.
+	SourcePrecedenceAttr
+)
+
+// replaceAttrFor returns the ReplaceAttr function for the logger name.
+func (o *ZapHandlerOptions) replaceAttrFor(loggerName string) func(groups []string, a slog.Attr) slog.Attr {
+	if fn, ok := o.ReplaceAttrByLogger[loggerName]; ok {
+		return fn
+	}
+	return o.ReplaceAttr
 }
 
 type ZapHandler struct {
@@ -28,123 +181,635 @@ type ZapHandler struct {
 	groupsIdxs []int
 	options    ZapHandlerOptions
 	loggerName string
+	// replaceAttr is the ReplaceAttr function selected for loggerName
+	replaceAttr func(groups []string, a slog.Attr) slog.Attr
+	coalescer   *coalescer
+	frames      *frameCache
+	// direct is set by NewZapHandlerDirect.  It's also the core, but Handle writes to it directly.
+	direct *directCore
 	// first dimension maps to open groups
 	// len(attrs) must always be len(groups) + 1
 	fields []zap.Field
 }
 
+// DefaultMaxGroupDepth is the default for ZapHandlerOptions.MaxGroupDepth.
+const DefaultMaxGroupDepth = 64
+
+// NewZapHandler returns a ZapHandler which writes to the core.  opts may be nil.  If core is nil,
+// the handler discards everything, like zapcore.NewNopCore.
 func NewZapHandler(core zapcore.Core, opts *ZapHandlerOptions) *ZapHandler {
 	if opts == nil {
 		opts = &ZapHandlerOptions{}
 	}
+	if core == nil {
+		core = zapcore.NewNopCore()
+	}
 	return &ZapHandler{
-		core:    core,
-		options: *opts,
+		core:        core,
+		options:     *opts,
+		replaceAttr: opts.replaceAttrFor(""),
+		coalescer:   newCoalescer(opts.CoalesceWindow),
+		frames:      newHandlerFrameCache(opts),
+	}
+}
+
+// NewLogger returns a slog.Logger which writes to a ZapHandler.
+func NewLogger(core zapcore.Core, opts *ZapHandlerOptions) *slog.Logger {
+	return slog.New(NewZapHandler(core, opts))
+}
+
+// AttrsToFields converts slog attributes to zap fields, the same way ZapHandler does with default
+// options.  LogValuers are resolved, groups are converted to nested fields, and empty attributes
+// are dropped.
+func AttrsToFields(attrs []slog.Attr) []zapcore.Field {
+	var h ZapHandler
+	fields := make([]zapcore.Field, 0, len(attrs))
+	for _, a := range attrs {
+		if f, ok := h.attrToField(nil, a, nil); ok {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// DefaultEncoderConfig returns a zapcore.EncoderConfig for building the core passed to NewZapHandler,
+// which writes entries like slog's built-in handlers: it uses slog's keys for the time, level,
+// message, and source, upper case levels like "INFO", and slog's text handler's time format,
+// RFC 3339 with milliseconds.  For lower case levels, set EncodeLevel to zapcore.LowercaseLevelEncoder.
+func DefaultEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        slog.TimeKey,
+		LevelKey:       slog.LevelKey,
+		NameKey:        "logger",
+		CallerKey:      slog.SourceKey,
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     slog.MessageKey,
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.CapitalLevelEncoder,
+		EncodeTime:     zapcore.TimeEncoderOfLayout("2006-01-02T15:04:05.000Z07:00"),
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.FullCallerEncoder,
 	}
 }
 
 func (h *ZapHandler) Enabled(ctx context.Context, level slog.Level) bool {
-	return h.core.Enabled(slogToZapLvl(level))
+	if h.options.StrictEnabled {
+		// check an entry without writing it
+		return h.core.Check(zapcore.Entry{Level: h.zapLevel(level), LoggerName: h.loggerName}, nil) != nil
+	}
+	return h.core.Enabled(h.zapLevel(level))
 }
 
+// Handle converts the record to a zap entry and writes it to the core.
+//
+// The record's time is passed through as the entry's time.  If it is zero, e.g. because the record
+// was constructed manually, the entry's time is zero too, unless ZapHandlerOptions.Clock is set.
+// zap's JSON and console encoders omit the time field for zero times.  Custom encoders should
+// check for a zero time, rather than rendering it as "0001-01-01T00:00:00Z".
+//
+// Like slog's built-in handlers, Handle reads the record's attributes while it runs, so the record
+// must not be modified concurrently.  Callers which share a record between goroutines should pass
+// each a copy, made with slog.Record.Clone before the record is shared: cloning it in Handle
+// would race with the concurrent writer too.
 func (h *ZapHandler) Handle(ctx context.Context, record slog.Record) error {
+	// bail before doing any work if the record's level is disabled, which is the same
+	// check slog.Logger makes with Enabled before calling Handle
+	if !h.core.Enabled(h.zapLevel(record.Level)) {
+		return nil
+	}
+
+	if h.options.SkipOnContextDone && ctx != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	level := record.Level
+	e := zapcore.Entry{
+		Time:    defaultTime(h.options.Clock, record.Time),
+		Message: record.Message,
+	}
+
+	if h.replaceAttr != nil {
+		level = h.replaceBuiltinAttrs(&e, level)
+	}
+
+	e.Level = h.zapLevel(level)
+
+	// ReplaceAttr may have changed the level.  The entry can't be checked yet, since the record's
+	// attributes may set the logger name.
+	if !h.core.Enabled(e.Level) {
+		return nil
+	}
+
+	var fields []zapcore.Field
+	var src *slog.Source
+	loggerName := h.loggerName
+	sourceAsField := h.options.AddSource && h.options.SourceAsField
+	noFields := true
+	if len(h.fields)+record.NumAttrs() > 0 || sourceAsField {
+		fields, loggerName, src = h.toFields(record, &e)
+		noFields = len(fields) == 0
+
+		if sourceAsField {
+			// added before the groups are applied, so it is nested in them
+			if caller, function := h.caller(record.PC, src); caller.Defined {
+				fields = append(fields, zap.Any(slog.SourceKey, []zapcore.Field{
+					zap.String("function", function),
+					zap.String("file", caller.File),
+					zap.Int("line", caller.Line),
+				}))
+			}
+		}
+
+		fields = h.applyGroups(fields)
+	}
+
+	if h.options.RequireFields && noFields {
+		return nil
+	}
+
+	if h.options.ContextAttrs != nil && ctx != nil {
+		for _, a := range h.options.ContextAttrs(ctx) {
+			if f, ok := h.attrToField(nil, a, nil); ok {
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	e.LoggerName = loggerName
+
+	// the direct core's level was checked above, and it doesn't need a CheckedEntry to write
+	var ce *zapcore.CheckedEntry
+	if h.direct == nil {
+		if ce = h.core.Check(e, nil); ce == nil {
+			return nil
+		}
+		e = ce.Entry
+	}
+
+	if h.options.AddSource && !sourceAsField {
+		e.Caller, _ = h.caller(record.PC, src)
+		if h.options.ShortSourceKey != "" && e.Caller.Defined {
+			fields = append(fields, zap.String(h.options.ShortSourceKey, e.Caller.TrimmedPath()))
+		}
+	}
+
+	if h.options.MessageHashKey != "" {
+		fields = append(fields, zap.String(h.options.MessageHashKey, MessageHash(e.Message)))
+	}
+
+	// dedupe once all the fields are assembled, so the fields added above are deduped too
+	if h.options.DedupeKeys {
+		fields = dedupeFields(fields)
+	}
+
+	if h.options.AfterEncode != nil {
+		h.options.AfterEncode(level, cloneFields(fields))
+	}
 
-	fields, loggerName := h.toFields(record)
+	var err error
+	suppressed := false
+	if h.coalescer != nil {
+		var pending *repeatedEntry
+		suppressed, pending = h.coalescer.suppress(h, e, fields)
+		err = pending.write()
+	}
+	written := false
+	if !suppressed {
+		if ce != nil {
+			ce.Entry = e
+			ce.Write(fields...)
+			written = true
+		} else {
+			writeErr := h.direct.Write(e, fields)
+			written = writeErr == nil
+			err = errors.Join(err, writeErr)
+		}
+	}
+
+	if written && h.options.OnFatal != nil && h.options.FatalLevel != nil && level >= h.options.FatalLevel.Level() {
+		h.options.OnFatal(record)
+	}
+
+	return err
+}
+
+// caller returns the caller from the record's PC or source attribute, according to SourcePrecedence,
+// and the caller's function.  The caller isn't defined if there is neither, or ReplaceAttr elides it.
+func (h *ZapHandler) caller(pc uintptr, src *slog.Source) (caller zapcore.EntryCaller, function string) {
+	switch {
+	case pc == 0 && src == nil:
+		return caller, ""
+	case src != nil && (pc == 0 || h.options.SourcePrecedence == SourcePrecedenceAttr):
+		caller = zapcore.NewEntryCaller(0, src.File, src.Line, true)
+		function = src.Function
+	default:
+		f := h.callerFrame(pc)
+		caller = zapcore.NewEntryCaller(f.PC, f.File, f.Line, true)
+		function = f.Function
+	}
+	if h.replaceAttr != nil {
+		caller = h.replaceSourceAttr(caller, function)
+	}
+	return caller, function
+}
+
+// maxCallerDepth is how many frames of the current stack callerFrame searches for the record's PC.
+const maxCallerDepth = 64
+
+// callerFrame returns the frame for the record's PC, or, if CallerSkip is set, the frame
+// CallerSkip frames above it.
+func (h *ZapHandler) callerFrame(pc uintptr) runtime.Frame {
+	if h.options.CallerSkip > 0 {
+		// the record's PC is a single frame, so find it in the current stack, which
+		// only works if the record is handled on the goroutine which logged it
+		var pcs [maxCallerDepth]uintptr
+		n := runtime.Callers(2, pcs[:])
+		if i := slices.Index(pcs[:n], pc); i >= 0 {
+			fs := runtime.CallersFrames(pcs[i:n])
+			skip := h.options.CallerSkip
+			for {
+				f, more := fs.Next()
+				if skip == 0 {
+					return f
+				}
+				if !more {
+					break
+				}
+				skip--
+			}
+		}
+	}
+	if h.frames != nil {
+		return h.frames.frame(pc, pcFrame)
+	}
+	return pcFrame(pc)
+}
+
+// pcFrame returns the frame for a PC.
+func pcFrame(pc uintptr) runtime.Frame {
+	fs := runtime.CallersFrames([]uintptr{pc})
+	f, _ := fs.Next()
+	f.PC = pc
+	return f
+}
 
-	// apply groups
+func newHandlerFrameCache(opts *ZapHandlerOptions) *frameCache {
+	if !opts.CacheCallerFrames {
+		return nil
+	}
+	return newFrameCache(frameCacheSize)
+}
+
+// applyGroups nests the fields added after each group was opened in the group.
+// fields is modified in place.
+func (h *ZapHandler) applyGroups(fields []zapcore.Field) []zapcore.Field {
 	for i := len(h.groups) - 1; i >= 0; i-- {
-		group := h.groups[i]
 		idx := h.groupsIdxs[i]
+		if idx >= len(fields) {
+			// empty groups are omitted
+			continue
+		}
 		subfields := slices.Clone(fields[idx:])
-		if len(subfields) > 0 {
-			fields = append(fields[:idx], zap.Any(group, subfields))
+		fields = append(fields[:idx], zap.Any(h.groups[i], subfields))
+	}
+	return fields
+}
+
+var fieldsType = reflect.TypeOf([]zapcore.Field(nil))
+
+// groupFields returns the members of a group field created with zap.Any(key, []zapcore.Field).
+func groupFields(f zapcore.Field) ([]zapcore.Field, bool) {
+	if f.Type != zapcore.ObjectMarshalerType {
+		return nil, false
+	}
+	v := reflect.ValueOf(f.Interface)
+	if v.Kind() != reflect.Slice || !v.Type().ConvertibleTo(fieldsType) || v.Type().Elem() != fieldsType.Elem() {
+		return nil, false
+	}
+	return v.Convert(fieldsType).Interface().([]zapcore.Field), true
+}
+
+// cloneFields returns a deep copy of fields: the members of group fields, including inlined
+// groups, are copied too, since they may be shared with the handler's fields.
+func cloneFields(fields []zapcore.Field) []zapcore.Field {
+	cloned := slices.Clone(fields)
+	for i, f := range cloned {
+		if members, ok := groupFields(f); ok {
+			cloned[i] = zap.Any(f.Key, cloneFields(members))
+		} else if members, ok := inlinedFields(f); ok {
+			cloned[i] = zap.Inline(zap.Dict("", cloneFields(members)...).Interface.(zapcore.ObjectMarshaler))
 		}
 	}
+	return cloned
+}
 
-	entry := h.core.Check(zapcore.Entry{
-		Level:      slogToZapLvl(record.Level),
-		Time:       record.Time,
-		LoggerName: loggerName,
-		Message:    record.Message,
-	}, nil)
+// inlinedFields returns the members of an inlined group field, created by attrToField for groups
+// with empty keys.
+func inlinedFields(f zapcore.Field) ([]zapcore.Field, bool) {
+	if f.Type != zapcore.InlineMarshalerType {
+		return nil, false
+	}
+	return groupFields(zapcore.Field{Type: zapcore.ObjectMarshalerType, Interface: f.Interface})
+}
 
-	if entry == nil {
-		return nil
+// dedupeFields drops fields whose key is repeated later in fields, then does the same in each
+// group field.  It returns fields unchanged if there are no duplicates.  Group fields may be
+// shared with the handler, so they're copied rather than modified.
+func dedupeFields(fields []zapcore.Field) []zapcore.Field {
+	var deduped []zapcore.Field
+	for i, f := range fields {
+		if f.Key != "" && slices.ContainsFunc(fields[i+1:], func(later zapcore.Field) bool { return later.Key == f.Key }) {
+			if deduped == nil {
+				deduped = slices.Clone(fields[:i])
+			}
+			continue
+		}
+		if members, ok := groupFields(f); ok {
+			if dm := dedupeFields(members); len(dm) != len(members) {
+				f = zap.Any(f.Key, dm)
+				if deduped == nil {
+					deduped = slices.Clone(fields[:i])
+				}
+			}
+		}
+		if deduped != nil {
+			deduped = append(deduped, f)
+		}
+	}
+	if deduped == nil {
+		return fields
 	}
+	return deduped
+}
+
+// Fields returns a copy of the fields added to the handler with WithAttrs, nested
+// in the groups added with WithGroup.  These are the fields Handle would write, minus the
+// record's attributes.
+func (h *ZapHandler) Fields() []zapcore.Field {
+	return h.applyGroups(slices.Clone(h.fields))
+}
 
-	if h.options.AddSource && record.PC != 0 {
-		fs := runtime.CallersFrames([]uintptr{record.PC})
-		f, _ := fs.Next()
-		entry.Caller = zapcore.NewEntryCaller(record.PC, f.File, f.Line, true)
+// Sync writes any pending coalesced entry, then syncs the zap core.  Call it at
+// shutdown to flush buffered cores, e.g. with a deferred handler.Sync() in main.
+func (h *ZapHandler) Sync() error {
+	if h.coalescer != nil {
+		if err := h.coalescer.flush(); err != nil {
+			return err
+		}
 	}
+	return h.core.Sync()
+}
 
-	entry.Write(fields...)
+// Close is an alias for Sync, so ZapHandler satisfies io.Closer.  The handler
+// may still be used after Close.
+func (h *ZapHandler) Close() error {
+	return h.Sync()
+}
 
+// writeRepeated writes a coalesced entry to the zap core.
+func (h *ZapHandler) writeRepeated(e zapcore.Entry, fields []zapcore.Field) error {
+	if ce := h.core.Check(e, nil); ce != nil {
+		ce.Write(fields...)
+	}
 	return nil
 }
 
-func (h *ZapHandler) toFields(record slog.Record) ([]zapcore.Field, string) {
-	cap := len(h.fields) + record.NumAttrs()
-	if cap <= 0 {
-		return nil, h.loggerName
+// replaceBuiltinAttrs passes the record's time, level, and message to ReplaceAttr, the same
+// way the slog built-in handlers do, and applies the results to the zap entry.  Changes to the
+// attribute keys are ignored: the zap encoder decides the keys.  Replacement values which can't be
+// interpreted as a time, level, or message are ignored as well.
+func (h *ZapHandler) replaceBuiltinAttrs(e *zapcore.Entry, level slog.Level) slog.Level {
+	if !e.Time.IsZero() {
+		a := h.replaceAttr(nil, slog.Time(slog.TimeKey, e.Time))
+		a.Value = a.Value.Resolve()
+		switch {
+		case a.Equal(slog.Attr{}):
+			e.Time = time.Time{}
+		case a.Value.Kind() == slog.KindTime:
+			e.Time = a.Value.Time()
+		}
 	}
 
-	fields := make([]zapcore.Field, len(h.fields), cap)
+	a := h.replaceAttr(nil, slog.Any(slog.LevelKey, level))
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindAny:
+		if l, ok := a.Value.Any().(slog.Leveler); ok {
+			level = l.Level()
+		}
+	case slog.KindInt64:
+		level = slog.Level(a.Value.Int64())
+	case slog.KindString:
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(a.Value.String())); err == nil {
+			level = l
+		}
+	}
+
+	a = h.replaceAttr(nil, slog.String(slog.MessageKey, e.Message))
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		e.Message = ""
+	} else {
+		e.Message = a.Value.String()
+	}
+
+	return level
+}
+
+// replaceSourceAttr passes the entry's caller to ReplaceAttr as a *slog.Source, the same
+// way the slog built-in handlers do.  If the source attribute is elided, the caller is
+// cleared.  If it is replaced with another *slog.Source or slog.Source, the caller's file
+// and line are updated.  Other replacement values are ignored.
+func (h *ZapHandler) replaceSourceAttr(caller zapcore.EntryCaller, function string) zapcore.EntryCaller {
+	a := h.replaceAttr(nil, slog.Any(slog.SourceKey, &slog.Source{
+		Function: function,
+		File:     caller.File,
+		Line:     caller.Line,
+	}))
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return zapcore.EntryCaller{}
+	}
+	if src := sourceValue(a.Value); src != nil {
+		return zapcore.NewEntryCaller(caller.PC, src.File, src.Line, true)
+	}
+	return caller
+}
+
+// derefPrimitive returns a field with the value pointed to by v, if v is a pointer to a bool, number,
+// string, or time.Time, so the field doesn't render as a pointer.  Nil pointers become nil fields,
+// the same as zap.Intp and friends.
+func derefPrimitive(key string, v any) (zapcore.Field, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer {
+		return zapcore.Field{}, false
+	}
+	switch rv.Type().Elem().Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Complex64, reflect.Complex128:
+	default:
+		if rv.Type().Elem() != timeType {
+			return zapcore.Field{}, false
+		}
+	}
+	if rv.IsNil() {
+		return zap.Reflect(key, nil), true
+	}
+	if t, ok := rv.Elem().Interface().(time.Time); ok {
+		return zap.Time(key, t.Round(0)), true
+	}
+	return zap.Any(key, rv.Elem().Interface()), true
+}
+
+// sourceValue returns the value's *slog.Source or slog.Source, or nil if it has neither.
+func sourceValue(v slog.Value) *slog.Source {
+	if v.Kind() != slog.KindAny {
+		return nil
+	}
+	switch src := v.Any().(type) {
+	case *slog.Source:
+		return src
+	case slog.Source:
+		return &src
+	}
+	return nil
+}
+
+// toFields returns the handler's fields and the record's attributes as fields.  If AddSource is set and
+// SourcePrecedence isn't SourcePrecedenceBoth, a top-level source attribute is returned instead
+// of being converted to a field.  If MessageKey is set, a matching attribute sets e's message.
+func (h *ZapHandler) toFields(record slog.Record, e *zapcore.Entry) ([]zapcore.Field, string, *slog.Source) {
+	fields := make([]zapcore.Field, len(h.fields), len(h.fields)+record.NumAttrs())
 	copy(fields, h.fields)
 
 	loggerName := h.loggerName
+	var src *slog.Source
 
 	groupless := len(h.groups) == 0
+	captureSource := groupless && h.options.AddSource && h.options.SourcePrecedence != SourcePrecedenceBoth
 
 	record.Attrs(func(a slog.Attr) bool {
-		if f, ok := h.attrToField(h.groups, a); ok {
+		if captureSource && a.Key == slog.SourceKey {
+			if s := sourceValue(h.resolveValue(a.Value)); s != nil {
+				src = s
+				return true
+			}
+		}
+		if f, ok := h.attrToField(h.groups, a, &loggerName); ok {
 			if groupless && f.Key == h.options.LoggerNameKey && f.Type == zapcore.StringType {
 				loggerName = f.String
 				// since we're capturing this field as the loggername, elide the field
 				return true
 			}
+			if groupless && h.options.MessageKey != "" && f.Key == h.options.MessageKey && f.Type == zapcore.StringType {
+				e.Message = f.String
+				return true
+			}
 			fields = append(fields, f)
 		}
 		return true
 	})
 
-	return fields, loggerName
+	return fields, loggerName, src
 }
 
 func (h *ZapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	fields, loggerName := h.attrsToFields(h.groups, attrs)
-	if len(fields) == 0 && loggerName == h.loggerName {
+	// h.fields is clipped, so the converted attrs are appended to a copy, which is allocated
+	// once at the combined size.  If all the attrs are elided, the parent's fields are shared.
+	loggerName := h.loggerName
+	fields := h.attrsToFields(slices.Clip(h.fields), h.groups, attrs, &loggerName)
+	if len(fields) == len(h.fields) && loggerName == h.loggerName {
 		// all attrs ended up being elided and logger name didn't change
 		return h
 	}
 	return &ZapHandler{
-		core:       h.core,
-		loggerName: loggerName,
-		groups:     slices.Clone(h.groups),
-		groupsIdxs: slices.Clone(h.groupsIdxs),
-		options:    h.options,
-		fields:     append(slices.Clone(h.fields), fields...),
+		core:        h.core,
+		loggerName:  loggerName,
+		replaceAttr: h.options.replaceAttrFor(loggerName),
+		coalescer:   h.coalescer,
+		frames:      h.frames,
+		direct:      h.direct,
+		groups:      slices.Clone(h.groups),
+		groupsIdxs:  slices.Clone(h.groupsIdxs),
+		options:     h.options,
+		fields:      fields,
 	}
 }
 
 func (h *ZapHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
 	return &ZapHandler{
-		core:       h.core,
-		loggerName: h.loggerName,
-		groups:     append(slices.Clone(h.groups), name),
-		groupsIdxs: append(slices.Clone(h.groupsIdxs), len(h.fields)),
-		options:    h.options,
-		fields:     slices.Clone(h.fields),
+		core:        h.core,
+		loggerName:  h.loggerName,
+		replaceAttr: h.replaceAttr,
+		coalescer:   h.coalescer,
+		frames:      h.frames,
+		direct:      h.direct,
+		groups:      append(slices.Clone(h.groups), name),
+		groupsIdxs:  append(slices.Clone(h.groupsIdxs), len(h.fields)),
+		options:     h.options,
+		fields:      slices.Clone(h.fields),
+	}
+}
+
+// WithOptions returns a copy of the handler, with options modified by mutate.  The copy
+// shares the handler's zap core, and keeps the attributes and groups already added to the handler.
+// Attributes already added aren't re-converted, so changes to options like ReplaceAttr or
+// LoggerNameKey only affect attributes added after this call.  The copy shares the handler's
+// coalescer, unless CoalesceWindow is changed.
+func (h *ZapHandler) WithOptions(mutate func(*ZapHandlerOptions)) *ZapHandler {
+	h2 := *h
+	mutate(&h2.options)
+	h2.replaceAttr = h2.options.replaceAttrFor(h2.loggerName)
+	if h2.options.CacheCallerFrames != (h2.frames != nil) {
+		h2.frames = newHandlerFrameCache(&h2.options)
 	}
+	if h2.options.CoalesceWindow != h.options.CoalesceWindow {
+		h2.coalescer = newCoalescer(h2.options.CoalesceWindow)
+	}
+	return &h2
 }
 
-func slogToZapLvl(zl slog.Level) zapcore.Level {
+// zapLevel maps the slog level to a zap level, according to LinearLevels.
+func (h *ZapHandler) zapLevel(l slog.Level) zapcore.Level {
+	if h.options.LinearLevels {
+		return linearSlogToZapLvl(l)
+	}
+	return SlogToZapLevel(l)
+}
+
+// linearSlogToZapLvl maps slog levels to zap levels, rounding up to multiples of 4, and clamping
+// to the range of zapcore.Level.
+func linearSlogToZapLvl(l slog.Level) zapcore.Level {
+	zl := int(l) / 4
+	if l > 0 && l%4 != 0 {
+		zl++
+	}
+	return zapcore.Level(max(math.MinInt8, min(math.MaxInt8, zl)))
+}
+
+// SlogToZapLevel maps slog levels to zap levels, the same way ZapHandler does by default.  Each
+// slog level is rounded up to the next of the standard levels:
+//
+//	slog.LevelDebug and below        zapcore.DebugLevel
+//	above LevelDebug to LevelInfo    zapcore.InfoLevel
+//	above LevelInfo to LevelWarn     zapcore.WarnLevel
+//	above LevelWarn                  zapcore.ErrorLevel
+func SlogToZapLevel(l slog.Level) zapcore.Level {
 	switch {
-	case zl <= slog.LevelDebug:
+	case l <= slog.LevelDebug:
 		return zapcore.DebugLevel
-	case zl <= slog.LevelInfo:
+	case l <= slog.LevelInfo:
 		return zapcore.InfoLevel
-	case zl <= slog.LevelWarn:
+	case l <= slog.LevelWarn:
 		return zapcore.WarnLevel
 	default:
 		return zapcore.ErrorLevel
@@ -153,39 +818,91 @@ func slogToZapLvl(zl slog.Level) zapcore.Level {
 
 func (h *ZapHandler) resolveAttr(groups []string, a slog.Attr) slog.Attr {
 
-	a.Value = a.Value.Resolve()
-	if a.Value.Kind() != slog.KindGroup && h.options.ReplaceAttr != nil {
-		a = h.options.ReplaceAttr(groups, a)
-		a.Value = a.Value.Resolve()
+	a.Value = h.resolveValue(a.Value)
+	if a.Value.Kind() != slog.KindGroup && h.replaceAttr != nil {
+		a = h.replaceAttr(groups, a)
+		a.Value = h.resolveValue(a.Value)
 	}
 
 	return a
 }
 
-func (h *ZapHandler) attrsToFields(groups []string, attrs []slog.Attr) ([]zapcore.Field, string) {
-	loggerName := h.loggerName
+// resolveValue resolves LogValuers, calling LogValue at most MaxLogValuerDepth times.
+func (h *ZapHandler) resolveValue(v slog.Value) slog.Value {
+	max := h.options.MaxLogValuerDepth
+	if max <= 0 || v.Kind() != slog.KindLogValuer {
+		return v.Resolve()
+	}
+	orig := v.Any()
+	for i := 0; i < max && v.Kind() == slog.KindLogValuer; i++ {
+		v = logValue(v.LogValuer())
+	}
+	if v.Kind() == slog.KindLogValuer {
+		return slog.StringValue(fmt.Sprintf("!LogValue exceeded MaxLogValuerDepth (%d) on value of type %T", max, orig))
+	}
+	return v
+}
+
+// logValue calls LogValue once, recovering from panics like slog.Value.Resolve does.
+func logValue(lv slog.LogValuer) (v slog.Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			v = slog.AnyValue(fmt.Errorf("LogValue panicked: %v", r))
+		}
+	}()
+	return lv.LogValue()
+}
 
+// attrsToFields converts attrs and appends them to fields.  fields is only grown when the first
+// attr is kept, and then only by the number of attrs remaining, so attrs elided by ReplaceAttr
+// don't over-allocate.  If fields has no spare capacity, e.g. because it was clipped, it's copied
+// before being appended to, so it's safe to pass a slice shared with other handlers.
+//
+// If loggerName isn't nil, attrs which aren't in any group and match LoggerNameKey are stored
+// in it instead of being converted to fields.
+func (h *ZapHandler) attrsToFields(fields []zapcore.Field, groups []string, attrs []slog.Attr, loggerName *string) []zapcore.Field {
 	if len(attrs) == 0 {
-		return nil, loggerName
+		return fields
 	}
 
-	groupless := len(h.groups) == 0
+	// only attrs which aren't in any group, including groups in attrs, can be the logger name.
+	// The members of inlined groups aren't in any group either.
+	capture := loggerName != nil && len(groups) == 0
 
-	fields := make([]zapcore.Field, 0, len(attrs))
-	for _, attr := range attrs {
-		if field, ok := h.attrToField(groups, attr); ok {
-			if groupless && field.Key == h.options.LoggerNameKey && field.Type == zapcore.StringType {
-				loggerName = field.String
+	start := len(fields)
+	grown := false
+	for i, attr := range attrs {
+		if field, ok := h.attrToField(groups, attr, loggerName); ok {
+			if capture && field.Key == h.options.LoggerNameKey && field.Type == zapcore.StringType {
+				*loggerName = field.String
 				// since we're capturing this field as the loggername, elide the field
 				continue
 			}
+			if !grown {
+				fields = slices.Grow(fields, len(attrs)-i)
+				grown = true
+			}
 			fields = append(fields, field)
 		}
 	}
-	return fields, loggerName
+	if grown && cap(fields)-start > 2*(len(fields)-start) {
+		// most of the attrs were elided.  These fields are retained by the handler or a group
+		// field, so don't hold on to the excess.
+		fields = slices.Clone(fields)
+	}
+	return fields
 }
 
-func (h *ZapHandler) attrToField(groups []string, attr slog.Attr) (field zapcore.Field, ok bool) {
+// attrToField converts the attr to a field.  If loggerName isn't nil, and attr is a group with an
+// empty key which isn't in any group, a logger name attr among its members is stored in loggerName,
+// since slog inlines the members of the group.
+func (h *ZapHandler) attrToField(groups []string, attr slog.Attr, loggerName *string) (field zapcore.Field, ok bool) {
+	for _, prefix := range h.options.DropKeyPrefixes {
+		if hasKeyPrefix(groups, attr.Key, prefix) {
+			return field, false
+		}
+	}
+
 	// resolve and apply ReplaceAttr
 	attr = h.resolveAttr(groups, attr)
 
@@ -206,17 +923,221 @@ func (h *ZapHandler) attrToField(groups []string, attr slog.Attr) (field zapcore
 	case slog.KindBool:
 		return zap.Bool(attr.Key, attr.Value.Bool()), true
 	case slog.KindTime:
-		return zap.Time(attr.Key, attr.Value.Time()), true
+		// the zap encoder's EncodeTime formats the time.  Strip the monotonic clock reading,
+		// so the field only depends on the wall clock time.
+		return zap.Time(attr.Key, attr.Value.Time().Round(0)), true
 	case slog.KindDuration:
 		return zap.Duration(attr.Key, attr.Value.Duration()), true
 	case slog.KindGroup:
-		fields, _ := h.attrsToFields(append(groups, attr.Key), attr.Value.Group())
+		var fields []zapcore.Field
+		if max := h.maxGroupDepth(); max >= 0 && len(groups) >= max {
+			fields = h.flattenGroup(groups, attr)
+			attr.Key = ""
+		} else {
+			if attr.Key != "" {
+				groups = append(groups, attr.Key)
+			}
+			fields = h.attrsToFields(nil, groups, attr.Value.Group(), loggerName)
+		}
 		if len(fields) == 0 {
 			return field, false
 		}
+		if attr.Key == "" {
+			// like the slog built-in handlers, inline the members of groups with empty keys
+			return zap.Inline(zap.Dict("", fields...).Interface.(zapcore.ObjectMarshaler)), true
+		}
 		return zap.Any(attr.Key, fields), true
 	default:
-		return zap.Any(attr.Key, attr.Value.Any()), true
+		v := attr.Value.Any()
+		switch v := v.(type) {
+		case []byte:
+			// same as zap.Any, but skip its type switch
+			return zap.Binary(attr.Key, v), true
+		case net.IP:
+			// net.IP and net.HardwareAddr are byte slices, which zap would reflect as
+			// arrays of numbers
+			return zap.Stringer(attr.Key, v), true
+		case net.HardwareAddr:
+			return zap.Stringer(attr.Key, v), true
+		case json.RawMessage:
+			// zap's encoders encode reflected values with encoding/json, which writes
+			// the raw JSON as is.  zap.Any might choose a different encoding, like
+			// a string if the type implements fmt.Stringer.
+			return zap.Reflect(attr.Key, v), true
+		}
+		if f, ok := derefPrimitive(attr.Key, v); ok {
+			return f, true
+		}
+		if err, ok := v.(error); ok && h.options.ExtractErrorStacks {
+			if ews, ok := newErrorWithStack(err, h.options.ErrorStackKey); ok {
+				return zap.Object(attr.Key, ews), true
+			}
+		}
+		if h.options.MapsAsObjects {
+			// maps nested too deeply, e.g. maps which contain themselves, are left to zap.Any,
+			// whose encoders detect cycles
+			if rv := reflect.ValueOf(v); rv.Kind() == reflect.Map && len(groups) < h.maxMapDepth() {
+				fields := h.mapToFields(append(slices.Clip(groups), attr.Key), rv)
+				if len(fields) == 0 {
+					return field, false
+				}
+				return zap.Any(attr.Key, fields), true
+			}
+		}
+		if s, ok := v.(fmt.Stringer); ok && !preferZapAny(v) {
+			return zap.Stringer(attr.Key, s), true
+		}
+		return zap.Any(attr.Key, v), true
+	}
+
+}
+
+func (h *ZapHandler) maxGroupDepth() int {
+	if h.options.MaxGroupDepth == 0 {
+		return DefaultMaxGroupDepth
 	}
+	return h.options.MaxGroupDepth
+}
+
+// maxMapDepth is how deeply MapsAsObjects nests maps.  Unlike groups, maps may contain
+// themselves, so they're limited even if MaxGroupDepth is negative.
+func (h *ZapHandler) maxMapDepth() int {
+	if max := h.maxGroupDepth(); max >= 0 {
+		return max
+	}
+	return DefaultMaxGroupDepth
+}
 
+// maxFlattenedDepth limits how deeply flattenGroup descends into nested groups, and
+// maxFlattenedAttrs how many attrs it visits, so groups which contain themselves, e.g. through
+// a LogValuer, don't loop forever.
+const (
+	maxFlattenedDepth = 1024
+	maxFlattenedAttrs = 1 << 16
+)
+
+// flattenGroup converts the group attr's members to fields with the members' keys prefixed
+// with their groups' keys, like "group.inner.key".  It doesn't recurse, and shares one slice of
+// group keys between all the nested groups, so it's safe for arbitrarily deep groups.  Groups
+// nested deeper than maxFlattenedDepth are replaced with a string describing the problem, and
+// after maxFlattenedAttrs attrs, the rest are dropped, the same way.
+func (h *ZapHandler) flattenGroup(groups []string, attr slog.Attr) []zapcore.Field {
+	type frame struct {
+		attrs []slog.Attr
+		// pushed is true if the group's key was appended to groups
+		pushed bool
+	}
+	var fields []zapcore.Field
+	base := len(groups)
+	groups = slices.Clip(groups)
+	prefixed := func(key string) string {
+		if len(groups) > base {
+			return strings.Join(groups[base:], ".") + "." + key
+		}
+		return key
+	}
+	stack := []frame{{attrs: []slog.Attr{attr}}}
+	for visited := 0; len(stack) > 0; {
+		top := &stack[len(stack)-1]
+		if len(top.attrs) == 0 {
+			if top.pushed {
+				groups = groups[:len(groups)-1]
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		a := top.attrs[0]
+		top.attrs = top.attrs[1:]
+
+		if visited++; visited > maxFlattenedAttrs {
+			msg := fmt.Sprintf("!group truncated after %d attributes", maxFlattenedAttrs)
+			return append(fields, zap.String(prefixed(a.Key), msg))
+		}
+
+		a.Value = h.resolveValue(a.Value)
+		if a.Value.Kind() == slog.KindGroup {
+			if len(stack) > maxFlattenedDepth {
+				msg := fmt.Sprintf("!group exceeded max depth (%d)", maxFlattenedDepth)
+				fields = append(fields, zap.String(prefixed(a.Key), msg))
+				continue
+			}
+			if a.Key != "" {
+				groups = append(groups, a.Key)
+			}
+			stack = append(stack, frame{attrs: a.Value.Group(), pushed: a.Key != ""})
+			continue
+		}
+		if f, ok := h.attrToField(groups, a, nil); ok {
+			f.Key = prefixed(f.Key)
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// hasKeyPrefix returns true if the key, prefixed with the groups and joined with ".", starts with
+// prefix.  It avoids joining the key.
+func hasKeyPrefix(groups []string, key, prefix string) bool {
+	for _, g := range groups {
+		if len(prefix) <= len(g) {
+			return strings.HasPrefix(g, prefix)
+		}
+		if !strings.HasPrefix(prefix, g) || prefix[len(g)] != '.' {
+			return false
+		}
+		prefix = prefix[len(g)+1:]
+	}
+	return strings.HasPrefix(key, prefix)
+}
+
+// preferZapAny returns true if zap.Any would encode v as something other than its String
+// method, even though v implements fmt.Stringer.
+func preferZapAny(v any) bool {
+	switch v.(type) {
+	case error, zapcore.ObjectMarshaler, zapcore.ArrayMarshaler:
+		return true
+	}
+	return false
+}
+
+// mapToFields converts the map's entries to fields, sorted by key.  Non-string
+// keys are converted to strings with fmt.Sprint.  Map values are converted like
+// attributes in a group with the map's key.
+func (h *ZapHandler) mapToFields(groups []string, m reflect.Value) []zapcore.Field {
+	if m.Len() == 0 {
+		return nil
+	}
+
+	keys := m.MapKeys()
+	slices.SortFunc(keys, compareMapKeys)
+
+	fields := make([]zapcore.Field, 0, len(keys))
+	for _, k := range keys {
+		var key string
+		if k.Kind() == reflect.String {
+			key = k.String()
+		} else {
+			key = fmt.Sprint(k.Interface())
+		}
+		if f, ok := h.attrToField(groups, slog.Any(key, m.MapIndex(k).Interface()), nil); ok {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// compareMapKeys orders numbers numerically, and everything else by its string form.
+func compareMapKeys(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.String:
+		return cmp.Compare(a.String(), b.String())
+	default:
+		return cmp.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+	}
 }
@@ -1,9 +1,20 @@
 package zap2slog
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/slogtest"
 	"time"
 
 	"log/slog"
@@ -12,6 +23,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestZapHandler_Enabled(t *testing.T) {
@@ -480,6 +492,206 @@ func TestZapHandler_Handle(t *testing.T) {
 				}),
 			},
 		},
+		{
+			name: "ReplaceAttr changes level",
+			opts: &ZapHandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && a.Key == slog.LevelKey {
+						return slog.String(slog.LevelKey, "ERROR")
+					}
+					return a
+				},
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+			},
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.ErrorLevel,
+				Message: "test message",
+			},
+		},
+		{
+			name: "ReplaceAttr changes level with a slog.Level",
+			opts: &ZapHandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && a.Key == slog.LevelKey {
+						return slog.Any("severity", a.Value.Any().(slog.Level)+4)
+					}
+					return a
+				},
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+			},
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.WarnLevel,
+				Message: "test message",
+			},
+		},
+		{
+			name: "ReplaceAttr elides time and replaces message",
+			opts: &ZapHandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					switch {
+					case len(groups) > 0:
+					case a.Key == slog.TimeKey:
+						return slog.Attr{}
+					case a.Key == slog.MessageKey:
+						return slog.String(a.Key, "replaced message")
+					}
+					return a
+				},
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+			},
+			wantEntry: zapcore.Entry{
+				Level:   zapcore.InfoLevel,
+				Message: "replaced message",
+			},
+		},
+		{
+			name: "ReplaceAttr replaces source",
+			opts: &ZapHandlerOptions{
+				AddSource: true,
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && a.Key == slog.SourceKey {
+						src := a.Value.Any().(*slog.Source)
+						return slog.Any(a.Key, &slog.Source{File: "replaced.go", Line: src.Line + 1})
+					}
+					return a
+				},
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+				PC:      pc,
+			},
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+				Caller:  zapcore.EntryCaller{Defined: true, PC: pc, File: "replaced.go", Line: line + 1},
+			},
+		},
+		{
+			name: "ReplaceAttr elides source",
+			opts: &ZapHandlerOptions{
+				AddSource: true,
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && a.Key == slog.SourceKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+				PC:      pc,
+			},
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+		},
+		{
+			name: "RequireFields drops message-only record",
+			opts: &ZapHandlerOptions{
+				LoggerNameKey: "logger",
+				RequireFields: true,
+			},
+			record: func() slog.Record {
+				r := slog.Record{
+					Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+					Level:   slog.LevelInfo,
+					Message: "test message",
+				}
+				r.AddAttrs(slog.String("logger", "mylogger"), slog.Group("empty"))
+				return r
+			}(),
+			wantEmpty: true,
+		},
+		{
+			name: "RequireFields passes record with a field",
+			opts: &ZapHandlerOptions{
+				RequireFields: true,
+			},
+			record: func() slog.Record {
+				r := slog.Record{
+					Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+					Level:   slog.LevelInfo,
+					Message: "test message",
+				}
+				r.AddAttrs(slog.String("user", "alice"))
+				return r
+			}(),
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+			wantFields: []zapcore.Field{
+				zap.String("user", "alice"),
+			},
+		},
+		{
+			name: "byte slice attribute",
+			record: func() slog.Record {
+				r := slog.Record{
+					Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+					Level:   slog.LevelInfo,
+					Message: "test message",
+				}
+				r.AddAttrs(slog.Any("data", []byte("hello")))
+				return r
+			}(),
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+			wantFields: []zapcore.Field{
+				{Key: "data", Type: zapcore.BinaryType, Interface: []byte("hello")},
+			},
+		},
+		{
+			name: "net addresses",
+			record: func() slog.Record {
+				r := slog.Record{
+					Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+					Level:   slog.LevelInfo,
+					Message: "test message",
+				}
+				r.AddAttrs(
+					slog.Any("ipv4", net.ParseIP("192.168.0.1")),
+					slog.Any("ipv6", net.ParseIP("2001:db8::1")),
+					slog.Any("mac", net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}),
+				)
+				return r
+			}(),
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+			wantFields: []zapcore.Field{
+				zap.Stringer("ipv4", net.ParseIP("192.168.0.1")),
+				zap.Stringer("ipv6", net.ParseIP("2001:db8::1")),
+				zap.Stringer("mac", net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e}),
+			},
+		},
 		{
 			name: "elided attribute from ReplaceAttr",
 			opts: &ZapHandlerOptions{
@@ -768,7 +980,7 @@ func TestZapHandler_WithAttrsAndGroups(t *testing.T) {
 			wantEntry: zapcore.Entry{
 				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
 				Level:   zapcore.InfoLevel,
-				Message: "config loaded",
+				Message: "test_config loaded", // ReplaceAttr applies to the message too
 			},
 			wantFields: []zapcore.Field{
 				zap.String("env", "test_prod"),
@@ -842,27 +1054,1914 @@ func TestZapHandler_WithAttrsAndGroups(t *testing.T) {
 				Message: "test message",
 			},
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			mockCore := &mockCoreRecorder{
-				mockCore: &mockCore{
-					enabledLevel: zapcore.InfoLevel,
+		{
+			name: "group with no children",
+			setup: func(h *ZapHandler) slog.Handler {
+				return h.WithAttrs([]slog.Attr{slog.String("env", "prod")}).WithGroup("req")
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+			},
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+			wantFields: []zapcore.Field{
+				zap.String("env", "prod"),
+			},
+		},
+		{
+			name: "group with only elided children",
+			opts: &ZapHandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == "secret" {
+						return slog.Attr{}
+					}
+					return a
 				},
-			}
-			baseHandler := NewZapHandler(mockCore, tt.opts)
-			h := tt.setup(baseHandler)
-
-			err := h.Handle(context.Background(), tt.record)
-			require.NoError(t, err)
-			require.NotNil(t, mockCore.lastEntry)
-
-			got := *mockCore.lastEntry
-			assert.Equal(t, tt.wantEntry, got)
-
-			gotFields := mockCore.lastFields
-			assert.Equal(t, tt.wantFields, gotFields)
+			},
+			setup: func(h *ZapHandler) slog.Handler {
+				return h.WithAttrs([]slog.Attr{slog.String("env", "prod")}).
+					WithGroup("req").
+					WithAttrs([]slog.Attr{slog.String("secret", "a"), slog.Group("empty")})
+			},
+			record: func() slog.Record {
+				r := slog.Record{
+					Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+					Level:   slog.LevelInfo,
+					Message: "test message",
+				}
+				r.AddAttrs(slog.String("secret", "b"), slog.Group("nested", slog.String("secret", "c")))
+				return r
+			}(),
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+			wantFields: []zapcore.Field{
+				zap.String("env", "prod"),
+			},
+		},
+		{
+			name: "nested empty groups",
+			setup: func(h *ZapHandler) slog.Handler {
+				return h.WithAttrs([]slog.Attr{slog.String("env", "prod")}).
+					WithGroup("a").
+					WithGroup("b").
+					WithGroup("c")
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+			},
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+			wantFields: []zapcore.Field{
+				zap.String("env", "prod"),
+			},
+		},
+		{
+			name: "empty inner group inside non-empty outer group",
+			setup: func(h *ZapHandler) slog.Handler {
+				return h.WithGroup("a").
+					WithAttrs([]slog.Attr{slog.String("host", "localhost")}).
+					WithGroup("b")
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+			},
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+			wantFields: []zapcore.Field{
+				zap.Any("a", []zapcore.Field{
+					zap.String("host", "localhost"),
+				}),
+			},
+		},
+		{
+			name: "only empty groups",
+			setup: func(h *ZapHandler) slog.Handler {
+				return h.WithGroup("a").WithGroup("b")
+			},
+			record: slog.Record{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   slog.LevelInfo,
+				Message: "test message",
+			},
+			wantEntry: zapcore.Entry{
+				Time:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Level:   zapcore.InfoLevel,
+				Message: "test message",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCore := &mockCoreRecorder{
+				mockCore: &mockCore{
+					enabledLevel: zapcore.InfoLevel,
+				},
+			}
+			baseHandler := NewZapHandler(mockCore, tt.opts)
+			h := tt.setup(baseHandler)
+
+			err := h.Handle(context.Background(), tt.record)
+			require.NoError(t, err)
+			require.NotNil(t, mockCore.lastEntry)
+
+			got := *mockCore.lastEntry
+			assert.Equal(t, tt.wantEntry, got)
+
+			gotFields := mockCore.lastFields
+			assert.Equal(t, tt.wantFields, gotFields)
+		})
+	}
+}
+
+func TestZapHandler_NonStandardLevels(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     slog.Level
+		coreLvl   zapcore.Level
+		wantLevel zapcore.Level
+		wantEmpty bool
+	}{
+		{
+			name:      "below debug",
+			level:     slog.Level(-6),
+			coreLvl:   zapcore.DebugLevel,
+			wantLevel: zapcore.DebugLevel,
+		},
+		{
+			name:      "between debug and info",
+			level:     slog.Level(-1),
+			coreLvl:   zapcore.InfoLevel,
+			wantLevel: zapcore.InfoLevel,
+		},
+		{
+			name:      "between info and warn",
+			level:     slog.Level(3),
+			coreLvl:   zapcore.WarnLevel,
+			wantLevel: zapcore.WarnLevel,
+		},
+		{
+			name:      "between info and warn, gated by error core",
+			level:     slog.Level(3),
+			coreLvl:   zapcore.ErrorLevel,
+			wantEmpty: true,
+		},
+		{
+			name:      "between warn and error",
+			level:     slog.Level(5),
+			coreLvl:   zapcore.ErrorLevel,
+			wantLevel: zapcore.ErrorLevel,
+		},
+		{
+			name:      "above error",
+			level:     slog.Level(12),
+			coreLvl:   zapcore.ErrorLevel,
+			wantLevel: zapcore.ErrorLevel,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(tt.coreLvl)
+			l := slog.New(NewZapHandler(core, nil))
+
+			assert.Equal(t, !tt.wantEmpty, l.Enabled(context.Background(), tt.level))
+
+			l.Log(context.Background(), tt.level, "test message")
+
+			if tt.wantEmpty {
+				assert.Zero(t, logs.Len())
+				return
+			}
+
+			entries := logs.TakeAll()
+			require.Len(t, entries, 1)
+			assert.Equal(t, tt.wantLevel, entries[0].Level)
+			assert.Equal(t, "test message", entries[0].Message)
+		})
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	mockCore := &mockCoreRecorder{
+		mockCore: &mockCore{
+			enabledLevel: zapcore.InfoLevel,
+		},
+	}
+
+	l := NewLogger(mockCore, &ZapHandlerOptions{LoggerNameKey: "logger"})
+	l.Info("hello, world", "logger", "mylogger", "user", "alice")
+
+	require.NotNil(t, mockCore.lastEntry)
+	assert.Equal(t, "hello, world", mockCore.lastEntry.Message)
+	assert.Equal(t, zapcore.InfoLevel, mockCore.lastEntry.Level)
+	assert.Equal(t, "mylogger", mockCore.lastEntry.LoggerName)
+	assert.Equal(t, []zapcore.Field{zap.String("user", "alice")}, mockCore.lastFields)
+}
+
+func TestZapHandler_AfterEncode(t *testing.T) {
+	mockCore := &mockCoreRecorder{
+		mockCore: &mockCore{
+			enabledLevel: zapcore.InfoLevel,
+		},
+	}
+
+	var calls int
+	var gotLevel slog.Level
+	var gotFields []zapcore.Field
+	h := NewZapHandler(mockCore, &ZapHandlerOptions{
+		LoggerNameKey: "logger",
+		AfterEncode: func(level slog.Level, fields []zapcore.Field) {
+			calls++
+			gotLevel = level
+			gotFields = fields
+			// modifying the fields should not affect the written entry
+			fields[0] = zap.String("corrupted", "corrupted")
+		},
+	})
+
+	l := slog.New(h).With("logger", "mylogger", "env", "prod").WithGroup("request")
+	l.Warn("hello", "method", "GET")
+
+	wantFields := []zapcore.Field{
+		zap.String("env", "prod"),
+		zap.Any("request", []zapcore.Field{
+			zap.String("method", "GET"),
+		}),
+	}
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, slog.LevelWarn, gotLevel)
+	assert.Equal(t, wantFields, mockCore.lastFields)
+	assert.Equal(t, zap.String("corrupted", "corrupted"), gotFields[0])
+	assert.Equal(t, wantFields[1:], gotFields[1:])
+
+	// modifying the members of groups, which are shared with the handler's fields, doesn't
+	// affect the handler either
+	h = NewZapHandler(mockCore, &ZapHandlerOptions{
+		AfterEncode: func(level slog.Level, fields []zapcore.Field) {
+			members, ok := groupFields(fields[0])
+			require.True(t, ok)
+			members[0] = zap.String("corrupted", "corrupted")
+			members, ok = inlinedFields(fields[1])
+			require.True(t, ok)
+			members[0] = zap.String("corrupted", "corrupted")
+		},
+	})
+	l = slog.New(h).With(slog.Group("g", "a", 1), slog.Group("", "b", 2))
+	wantFields = []zapcore.Field{
+		zap.Any("g", []zapcore.Field{zap.Int64("a", 1)}),
+		zap.Inline(zap.Dict("", zap.Int64("b", 2)).Interface.(zapcore.ObjectMarshaler)),
+	}
+	l.Info("first")
+	assert.Equal(t, wantFields, mockCore.lastFields)
+	l.Info("second")
+	assert.Equal(t, wantFields, mockCore.lastFields)
+
+	// not called for disabled levels
+	l.Debug("hello")
+	assert.Equal(t, 1, calls)
+}
+
+func TestZapHandler_TimeMonotonic(t *testing.T) {
+	now := time.Now()
+	wall := now.Round(0)
+	require.NotEqual(t, now.String(), wall.String(), "expected time.Now() to have a monotonic clock reading")
+
+	fieldsFor := func(tm time.Time) []zapcore.Field {
+		mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+		slog.New(NewZapHandler(mockCore, nil)).Info("test message", "time", tm)
+		return mockCore.lastFields
+	}
+
+	assert.Equal(t, fieldsFor(wall), fieldsFor(now))
+
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{EncodeTime: zapcore.RFC3339NanoTimeEncoder})
+	encode := func(fields []zapcore.Field) string {
+		buf, err := enc.EncodeEntry(zapcore.Entry{}, fields)
+		require.NoError(t, err)
+		return buf.String()
+	}
+	assert.Equal(t, encode(fieldsFor(wall)), encode(fieldsFor(now)))
+}
+
+func TestZapHandler_ReplaceAttrByLogger(t *testing.T) {
+	redact := func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == "card" {
+			return slog.String(a.Key, "REDACTED")
+		}
+		return a
+	}
+	prefix := func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == "card" {
+			return slog.String(a.Key, "default_"+a.Value.String())
+		}
+		return a
+	}
+
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	l := NewLogger(mockCore, &ZapHandlerOptions{
+		LoggerNameKey:       "logger",
+		ReplaceAttr:         prefix,
+		ReplaceAttrByLogger: map[string]func([]string, slog.Attr) slog.Attr{"payments": redact},
+	})
+
+	l.With("logger", "payments").WithGroup("details").Info("charge", "card", "visa")
+	assert.Equal(t, "payments", mockCore.lastEntry.LoggerName)
+	assert.Equal(t, []zapcore.Field{
+		zap.Any("details", []zapcore.Field{zap.String("card", "REDACTED")}),
+	}, mockCore.lastFields)
+
+	l.With("logger", "orders").Info("charge", "card", "visa")
+	assert.Equal(t, "orders", mockCore.lastEntry.LoggerName)
+	assert.Equal(t, []zapcore.Field{zap.String("card", "default_visa")}, mockCore.lastFields)
+
+	l.Info("charge", "card", "visa")
+	assert.Equal(t, []zapcore.Field{zap.String("card", "default_visa")}, mockCore.lastFields)
+}
+
+func TestZapHandler_WithOptions(t *testing.T) {
+	pc, file, line, ok := runtime.Caller(0)
+	require.True(t, ok)
+
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	parent := NewZapHandler(mockCore, &ZapHandlerOptions{LoggerNameKey: "logger"}).
+		WithAttrs([]slog.Attr{slog.String("logger", "mylogger"), slog.String("env", "prod")}).(*ZapHandler).
+		WithGroup("request").(*ZapHandler)
+
+	child := parent.WithOptions(func(o *ZapHandlerOptions) {
+		o.AddSource = true
+	})
+
+	record := slog.NewRecord(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), slog.LevelInfo, "test message", pc)
+	record.AddAttrs(slog.String("method", "GET"))
+
+	wantFields := []zapcore.Field{
+		zap.String("env", "prod"),
+		zap.Any("request", []zapcore.Field{zap.String("method", "GET")}),
+	}
+
+	require.NoError(t, child.Handle(context.Background(), record))
+	assert.Equal(t, zapcore.EntryCaller{Defined: true, PC: pc, File: file, Line: line}, mockCore.lastEntry.Caller)
+	assert.Equal(t, "mylogger", mockCore.lastEntry.LoggerName)
+	assert.Equal(t, wantFields, mockCore.lastFields)
+
+	require.NoError(t, parent.Handle(context.Background(), record))
+	assert.False(t, mockCore.lastEntry.Caller.Defined)
+	assert.False(t, parent.options.AddSource)
+	assert.Equal(t, "mylogger", mockCore.lastEntry.LoggerName)
+	assert.Equal(t, wantFields, mockCore.lastFields)
+}
+
+func TestZapHandler_Fields(t *testing.T) {
+	h := NewZapHandler(&mockCore{}, nil)
+	assert.Empty(t, h.Fields())
+
+	h2 := h.WithAttrs([]slog.Attr{slog.String("env", "prod")}).
+		WithGroup("a").
+		WithAttrs([]slog.Attr{slog.String("host", "localhost"), slog.Int("port", 8080)}).
+		WithGroup("b").(*ZapHandler)
+
+	want := []zapcore.Field{
+		zap.String("env", "prod"),
+		zap.Any("a", []zapcore.Field{
+			zap.String("host", "localhost"),
+			zap.Int("port", 8080),
+		}),
+	}
+	got := h2.Fields()
+	assert.Equal(t, want, got)
+
+	// modifying the returned fields doesn't affect the handler
+	got[0] = zap.String("corrupted", "corrupted")
+	assert.Equal(t, want, h2.Fields())
+}
+
+func TestZapHandler_MapsAsObjects(t *testing.T) {
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	l := NewLogger(mockCore, &ZapHandlerOptions{MapsAsObjects: true})
+
+	l.Info("test message",
+		"strs", map[string]int{"c": 3, "a": 1, "b": 2},
+		"ints", map[int]string{10: "ten", 2: "two", 1: "one"},
+		"nested", map[string]any{"m": map[string]bool{"y": true, "x": false}, "data": []byte("hi")},
+		"empty", map[string]int{},
+	)
+
+	assert.Equal(t, []zapcore.Field{
+		zap.Dict("strs", zap.Int("a", 1), zap.Int("b", 2), zap.Int("c", 3)),
+		zap.Dict("ints", zap.String("1", "one"), zap.String("2", "two"), zap.String("10", "ten")),
+		zap.Dict("nested",
+			zap.Binary("data", []byte("hi")),
+			zap.Dict("m", zap.Bool("x", false), zap.Bool("y", true)),
+		),
+	}, mockCore.lastFields)
+
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{})
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, mockCore.lastFields)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"strs":{"a":1,"b":2,"c":3},"ints":{"1":"one","2":"two","10":"ten"},"nested":{"data":"aGk=","m":{"x":false,"y":true}}}`, buf.String())
+	// key order is deterministic
+	assert.Contains(t, buf.String(), `"ints":{"1":"one","2":"two","10":"ten"}`)
+
+	// without the option, maps are passed to zap.Any
+	l = NewLogger(mockCore, nil)
+	l.Info("test message", "strs", map[string]int{"a": 1})
+	assert.Equal(t, []zapcore.Field{zap.Any("strs", map[string]int{"a": 1})}, mockCore.lastFields)
+}
+
+func TestZapHandler_MapsAsObjects_Cycle(t *testing.T) {
+	cyclic := map[string]any{"a": 1}
+	cyclic["self"] = cyclic
+
+	for _, max := range []int{0, 3, -1} {
+		t.Run(strconv.Itoa(max), func(t *testing.T) {
+			var buf bytes.Buffer
+			core := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{}), zapcore.AddSync(&buf), zapcore.InfoLevel)
+			NewLogger(core, &ZapHandlerOptions{MapsAsObjects: true, MaxGroupDepth: max}).Info("msg", "m", cyclic)
+
+			// the map is converted to objects up to the limit, then zap's reflection reports the cycle
+			depth := max
+			if depth <= 0 {
+				depth = DefaultMaxGroupDepth
+			}
+			assert.Equal(t, depth, strings.Count(buf.String(), `"a":1`))
+			assert.Contains(t, buf.String(), "encountered a cycle")
+		})
+	}
+
+	// sibling keys don't share the groups' backing array, which would show up in groups retained
+	// by ReplaceAttr
+	var gotGroups [][]string
+
+	core, _ := observer.New(zapcore.InfoLevel)
+	NewLogger(core, &ZapHandlerOptions{
+		MapsAsObjects: true,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == "k" {
+				gotGroups = append(gotGroups, groups)
+			}
+			return a
+		},
+	}).WithGroup("g").Info("msg", slog.Group("a", slog.Group("b", "x", map[string]int{"k": 1}, "y", map[string]int{"k": 2})))
+	assert.Equal(t, [][]string{{"g", "a", "b", "x"}, {"g", "a", "b", "y"}}, gotGroups)
+}
+
+func TestZapHandler_OnFatal(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	var exitCodes []int
+	fakeExit := func(code int) {
+		exitCodes = append(exitCodes, code)
+	}
+
+	const LevelFatal = slog.Level(12)
+	l := NewLogger(core, &ZapHandlerOptions{
+		FatalLevel: LevelFatal,
+		OnFatal: func(r slog.Record) {
+			// the record has already been written
+			require.Equal(t, 1, logs.FilterMessage(r.Message).Len())
+			fakeExit(1)
+		},
+	})
+
+	l.Error("error")
+	assert.Empty(t, exitCodes)
+
+	l.Log(context.Background(), LevelFatal, "fatal")
+	assert.Equal(t, []int{1}, exitCodes)
+
+	l.Log(context.Background(), LevelFatal+4, "above fatal")
+	assert.Equal(t, []int{1, 1}, exitCodes)
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3)
+	assert.Equal(t, zapcore.ErrorLevel, entries[1].Level)
+
+	// no FatalLevel, no OnFatal
+	l = NewLogger(core, &ZapHandlerOptions{OnFatal: func(slog.Record) { fakeExit(2) }})
+	l.Log(context.Background(), LevelFatal, "fatal")
+	assert.Equal(t, []int{1, 1}, exitCodes)
+
+	// not called if the record wasn't written
+	onFatal := &ZapHandlerOptions{FatalLevel: LevelFatal, OnFatal: func(slog.Record) { fakeExit(3) }}
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{})
+	failing := NewZapHandlerDirect(enc, zapcore.AddSync(failingWriter{}), zapcore.DebugLevel, onFatal)
+	r := slog.NewRecord(time.Now(), LevelFatal, "fatal", 0)
+	require.Error(t, failing.Handle(context.Background(), r))
+	assert.Equal(t, []int{1, 1}, exitCodes)
+
+	coalesced := *onFatal
+	coalesced.CoalesceWindow = time.Hour
+	l = NewLogger(core, &coalesced)
+	l.Log(context.Background(), LevelFatal, "fatal")
+	l.Log(context.Background(), LevelFatal, "fatal")
+	assert.Equal(t, []int{1, 1, 3}, exitCodes)
+}
+
+func TestZapHandler_AddSourceWithReplaceAttr(t *testing.T) {
+	_, file, _, ok := runtime.Caller(0)
+	require.True(t, ok)
+
+	tests := []struct {
+		name        string
+		replaceAttr func(groups []string, a slog.Attr) slog.Attr
+		wantCaller  func(line int) string
+	}{
+		{
+			name:        "source kept",
+			replaceAttr: func(groups []string, a slog.Attr) slog.Attr { return a },
+			wantCaller: func(line int) string {
+				return fmt.Sprintf("%s:%d", file, line)
+			},
+		},
+		{
+			name: "source dropped",
+			replaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.SourceKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		},
+		{
+			name: "source file trimmed",
+			replaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.SourceKey {
+					src := a.Value.Any().(*slog.Source)
+					return slog.Any(a.Key, slog.Source{File: filepath.Base(src.File), Line: src.Line})
+				}
+				return a
+			},
+			wantCaller: func(line int) string {
+				return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			core := zapcore.NewCore(
+				zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+					MessageKey:   "msg",
+					CallerKey:    "caller",
+					EncodeCaller: zapcore.FullCallerEncoder,
+				}),
+				zapcore.AddSync(&buf),
+				zapcore.InfoLevel,
+			)
+			l := NewLogger(core, &ZapHandlerOptions{AddSource: true, ReplaceAttr: tt.replaceAttr})
+
+			_, _, line, _ := runtime.Caller(0)
+			l.Info("test message")
+
+			if tt.wantCaller == nil {
+				assert.JSONEq(t, `{"msg":"test message"}`, buf.String())
+				return
+			}
+			assert.JSONEq(t, fmt.Sprintf(`{"msg":"test message","caller":%q}`, tt.wantCaller(line+1)), buf.String())
+		})
+	}
+}
+
+func TestZapHandler_ShortSourceKey(t *testing.T) {
+	pc, file, line, ok := runtime.Caller(0)
+	require.True(t, ok)
+
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	h := NewZapHandler(mockCore, &ZapHandlerOptions{AddSource: true, ShortSourceKey: "caller"}).WithGroup("req")
+
+	record := slog.NewRecord(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), slog.LevelInfo, "test message", pc)
+	record.AddAttrs(slog.String("method", "GET"))
+	require.NoError(t, h.Handle(context.Background(), record))
+
+	wantCaller := zapcore.EntryCaller{Defined: true, PC: pc, File: file, Line: line}
+	assert.Equal(t, wantCaller, mockCore.lastEntry.Caller)
+	assert.Equal(t, []zapcore.Field{
+		zap.Any("req", []zapcore.Field{zap.String("method", "GET")}),
+		zap.String("caller", fmt.Sprintf("%s/%s:%d", filepath.Base(filepath.Dir(file)), filepath.Base(file), line)),
+	}, mockCore.lastFields)
+
+	// no caller, no field
+	record.PC = 0
+	require.NoError(t, h.Handle(context.Background(), record))
+	assert.False(t, mockCore.lastEntry.Caller.Defined)
+	assert.Equal(t, []zapcore.Field{
+		zap.Any("req", []zapcore.Field{zap.String("method", "GET")}),
+	}, mockCore.lastFields)
+}
+
+func TestZapHandler_JSONRawMessage(t *testing.T) {
+	var buf strings.Builder
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"}),
+		zapcore.AddSync(&buf),
+		zapcore.InfoLevel,
+	)
+
+	raw := json.RawMessage(`{"a":1,"b":["x","y"]}`)
+	NewLogger(core, nil).Info("test message", "raw", raw, slog.Group("g", slog.Any("raw", raw)))
+
+	assert.Equal(t, `{"msg":"test message","raw":{"a":1,"b":["x","y"]},"g":{"raw":{"a":1,"b":["x","y"]}}}`+"\n", buf.String())
+}
+
+func TestZapHandler_LoggerNamePromotionScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(l *slog.Logger) *slog.Logger
+		attrs      []any
+		wantName   string
+		wantFields []zapcore.Field
+	}{
+		{
+			name:       "top-level attr is promoted",
+			attrs:      []any{slog.String("logger", "x")},
+			wantName:   "x",
+			wantFields: []zapcore.Field{},
+		},
+		{
+			name:  "attr in record group is not promoted",
+			attrs: []any{slog.Group("meta", slog.String("logger", "x"))},
+			wantFields: []zapcore.Field{
+				zap.Any("meta", []zapcore.Field{zap.String("logger", "x")}),
+			},
+		},
+		{
+			name: "attr in WithAttrs group is not promoted",
+			setup: func(l *slog.Logger) *slog.Logger {
+				return l.With(slog.Group("meta", slog.String("logger", "x")))
+			},
+			wantFields: []zapcore.Field{
+				zap.Any("meta", []zapcore.Field{zap.String("logger", "x")}),
+			},
+		},
+		{
+			name: "attr in open group is not promoted",
+			setup: func(l *slog.Logger) *slog.Logger {
+				return l.WithGroup("meta")
+			},
+			attrs: []any{slog.String("logger", "x")},
+			wantFields: []zapcore.Field{
+				zap.Any("meta", []zapcore.Field{zap.String("logger", "x")}),
+			},
+		},
+		{
+			// slog inlines the members of groups with empty keys, so they're top-level
+			name:     "attr in inlined record group is promoted",
+			attrs:    []any{slog.Group("", slog.String("logger", "x"), slog.Int("n", 1))},
+			wantName: "x",
+			wantFields: []zapcore.Field{
+				zap.Inline(zap.Dict("", zap.Int64("n", 1)).Interface.(zapcore.ObjectMarshaler)),
+			},
+		},
+		{
+			name: "attr in inlined WithAttrs group is promoted",
+			setup: func(l *slog.Logger) *slog.Logger {
+				return l.With(slog.Group("", slog.String("logger", "x"), slog.Int("n", 1)))
+			},
+			wantName: "x",
+			wantFields: []zapcore.Field{
+				zap.Inline(zap.Dict("", zap.Int64("n", 1)).Interface.(zapcore.ObjectMarshaler)),
+			},
+		},
+		{
+			name: "attr in inlined group in open group is not promoted",
+			setup: func(l *slog.Logger) *slog.Logger {
+				return l.WithGroup("meta")
+			},
+			attrs: []any{slog.Group("", slog.String("logger", "x"))},
+			wantFields: []zapcore.Field{
+				zap.Any("meta", []zapcore.Field{
+					zap.Inline(zap.Dict("", zap.String("logger", "x")).Interface.(zapcore.ObjectMarshaler)),
+				}),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+			l := NewLogger(mockCore, &ZapHandlerOptions{LoggerNameKey: "logger"})
+			if tt.setup != nil {
+				l = tt.setup(l)
+			}
+			l.Info("test message", tt.attrs...)
+
+			require.NotNil(t, mockCore.lastEntry)
+			assert.Equal(t, tt.wantName, mockCore.lastEntry.LoggerName)
+			assert.Equal(t, tt.wantFields, mockCore.lastFields)
+		})
+	}
+}
+
+func BenchmarkZapHandler(b *testing.B) {
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(io.Discard), zapcore.InfoLevel)
+	h := NewZapHandler(core, nil).WithAttrs([]slog.Attr{slog.String("service", "api")})
+
+	newRecord := func(level slog.Level) slog.Record {
+		r := slog.NewRecord(time.Now(), level, "benchmark", 0)
+		r.AddAttrs(
+			slog.String("method", "POST"),
+			slog.Int("status", 200),
+			slog.String("id", "123"),
+			slog.String("name", "alice"),
+		)
+		return r
+	}
+
+	b.Run("disabled", func(b *testing.B) {
+		r := newRecord(slog.LevelDebug)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = h.Handle(context.Background(), r)
+		}
+	})
+
+	b.Run("disabled via slog.Log", func(b *testing.B) {
+		l := slog.New(h)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Log(context.Background(), slog.LevelDebug, "benchmark", "method", "POST", "status", 200)
+		}
+	})
+
+	b.Run("enabled", func(b *testing.B) {
+		r := newRecord(slog.LevelInfo)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = h.Handle(context.Background(), r)
+		}
+	})
+}
+
+func BenchmarkZapHandler_WithAttrs(b *testing.B) {
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(io.Discard), zapcore.InfoLevel)
+
+	// ReplaceAttr elides 9 out of 10 attrs
+	attrs := make([]slog.Attr, 100)
+	for i := range attrs {
+		attrs[i] = slog.Int("k"+strconv.Itoa(i), i)
+	}
+	h := NewZapHandler(core, &ZapHandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Value.Kind() == slog.KindInt64 && a.Value.Int64()%10 != 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}).WithAttrs([]slog.Attr{slog.String("service", "api")})
+
+	b.Run("attrs", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = h.WithAttrs(attrs)
+		}
+	})
+
+	b.Run("group", func(b *testing.B) {
+		group := []slog.Attr{{Key: "g", Value: slog.GroupValue(attrs...)}}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = h.WithAttrs(group)
+		}
+	})
+}
+
+func TestZapHandler_CoreMayRetainFields(t *testing.T) {
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	l := NewLogger(mockCore, nil).With("service", "api")
+
+	l.Info("first", "n", 1)
+	first := mockCore.lastFields
+	l.Info("second", "n", 2)
+	assert.Equal(t, []zapcore.Field{zap.String("service", "api"), zap.Int64("n", 1)}, first)
+	assert.Equal(t, []zapcore.Field{zap.String("service", "api"), zap.Int64("n", 2)}, mockCore.lastFields)
+}
+
+func TestZapHandler_DisabledLevelDoesNotAllocate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *ZapHandlerOptions
+	}{
+		{name: "no options"},
+		{name: "options which do work in Handle", opts: &ZapHandlerOptions{
+			AddSource:      true,
+			MessageHashKey: "hash",
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				return a
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+			h := NewZapHandler(mockCore, tt.opts).WithAttrs([]slog.Attr{slog.String("service", "api")}).WithGroup("g")
+
+			pc, _, _, _ := runtime.Caller(0)
+			r := slog.NewRecord(time.Now(), slog.LevelDebug, "debug", pc)
+			r.AddAttrs(slog.String("method", "POST"), slog.Int("status", 200))
+
+			allocs := testing.AllocsPerRun(100, func() {
+				_ = h.Handle(context.Background(), r)
+			})
+			assert.Zero(t, allocs)
+			assert.Nil(t, mockCore.lastEntry)
+		})
+	}
+}
+
+func TestZapHandler_MaxLogValuerDepth(t *testing.T) {
+	// chain returns a LogValuer which must be resolved n times to get "done"
+	var chain func(n int) slog.LogValuer
+	chain = func(n int) slog.LogValuer {
+		return logValuerFunc(func() slog.Value {
+			if n <= 1 {
+				return slog.StringValue("done")
+			}
+			return slog.AnyValue(chain(n - 1))
+		})
+	}
+
+	tests := []struct {
+		name     string
+		maxDepth int
+		depth    int
+		want     string
+	}{
+		{name: "under the cap", maxDepth: 5, depth: 3, want: "done"},
+		{name: "at the cap", maxDepth: 5, depth: 5, want: "done"},
+		{name: "over the cap", maxDepth: 5, depth: 6, want: "!LogValue exceeded MaxLogValuerDepth (5) on value of type zap2slog.logValuerFunc"},
+		{name: "unlimited", maxDepth: 0, depth: 20, want: "done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+			l := NewLogger(mockCore, &ZapHandlerOptions{MaxLogValuerDepth: tt.maxDepth})
+
+			l.Info("msg", slog.Any("v", chain(tt.depth)))
+			assert.Equal(t, []zapcore.Field{zap.String("v", tt.want)}, mockCore.lastFields)
+
+			// also applies to attrs added with WithAttrs
+			l.With(slog.Any("v", chain(tt.depth))).Info("msg")
+			assert.Equal(t, []zapcore.Field{zap.String("v", tt.want)}, mockCore.lastFields)
+		})
+	}
+}
+
+func TestZapHandler_Slogtest(t *testing.T) {
+	var buf bytes.Buffer
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		TimeKey:     slog.TimeKey,
+		LevelKey:    slog.LevelKey,
+		MessageKey:  slog.MessageKey,
+		EncodeTime:  zapcore.RFC3339NanoTimeEncoder,
+		EncodeLevel: zapcore.CapitalLevelEncoder,
+	})
+	core := zapcore.NewCore(enc, zapcore.AddSync(&buf), zapcore.DebugLevel)
+
+	err := slogtest.TestHandler(NewZapHandler(core, nil), func() []map[string]any {
+		var ms []map[string]any
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			m := map[string]any{}
+			require.NoError(t, json.Unmarshal(line, &m))
+			ms = append(ms, m)
+		}
+		return ms
+	})
+	require.NoError(t, err)
+}
+
+func TestZapHandler_Fanout(t *testing.T) {
+	zapCore, logs := observer.New(zapcore.InfoLevel)
+	var buf bytes.Buffer
+	jsonHandler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	zh := NewZapHandler(zapCore, &ZapHandlerOptions{
+		LoggerNameKey: "logger",
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == "password" {
+				return slog.String(a.Key, "***")
+			}
+			return a
+		},
+	})
+
+	l := slog.New(NewTeeHandler(zh, jsonHandler))
+
+	// only the json handler is enabled for debug
+	l.Debug("debug")
+	assert.Zero(t, logs.Len())
+	assert.Contains(t, buf.String(), `"msg":"debug"`)
+	buf.Reset()
+
+	l.With("logger", "db", "password", "secret").
+		WithGroup("req").
+		With("id", 1).
+		WithGroup("").
+		Info("hello", "user", "alice", slog.Group("", "inlined", true))
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	// the derived handlers kept the options
+	assert.Equal(t, "db", entries[0].LoggerName)
+	assert.Equal(t, "hello", entries[0].Message)
+	assert.Equal(t, map[string]any{
+		"password": "***",
+		"req": map[string]any{
+			"id":      int64(1),
+			"user":    "alice",
+			"inlined": true,
+		},
+	}, entries[0].ContextMap())
+
+	// the other handler got an unmodified record
+	assert.JSONEq(t,
+		`{"level":"INFO","msg":"hello","logger":"db","password":"secret","req":{"id":1,"user":"alice","inlined":true}}`,
+		buf.String())
+}
+
+func TestZapHandler_ZeroTime(t *testing.T) {
+	encCfg := zapcore.EncoderConfig{
+		TimeKey:     "ts",
+		LevelKey:    "level",
+		MessageKey:  "msg",
+		EncodeTime:  zapcore.RFC3339TimeEncoder,
+		EncodeLevel: zapcore.LowercaseLevelEncoder,
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		enc     zapcore.Encoder
+		time    time.Time
+		want    string
+		wantNot string
+	}{
+		{name: "json zero", enc: zapcore.NewJSONEncoder(encCfg), want: `{"level":"info","msg":"hi"}`, wantNot: "ts"},
+		{name: "json non-zero", enc: zapcore.NewJSONEncoder(encCfg), time: ts, want: `{"level":"info","ts":"2024-01-02T03:04:05Z","msg":"hi"}`},
+		{name: "console zero", enc: zapcore.NewConsoleEncoder(encCfg), want: "info\thi", wantNot: "0001"},
+		{name: "console non-zero", enc: zapcore.NewConsoleEncoder(encCfg), time: ts, want: "2024-01-02T03:04:05Z\tinfo\thi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewZapHandler(zapcore.NewCore(tt.enc, zapcore.AddSync(&buf), zapcore.DebugLevel), nil)
+
+			require.NoError(t, h.Handle(context.Background(), slog.NewRecord(tt.time, slog.LevelInfo, "hi", 0)))
+			assert.Equal(t, tt.want, strings.TrimSpace(buf.String()))
+			if tt.wantNot != "" {
+				assert.NotContains(t, buf.String(), tt.wantNot)
+			}
+		})
+	}
+
+	t.Run("zero time passed through to entry", func(t *testing.T) {
+		mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.DebugLevel}}
+		h := NewZapHandler(mockCore, &ZapHandlerOptions{
+			// not called with the zero time
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Time(slog.TimeKey, ts)
+				}
+				return a
+			},
+		})
+		require.NoError(t, h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "hi", 0)))
+		require.NotNil(t, mockCore.lastEntry)
+		assert.True(t, mockCore.lastEntry.Time.IsZero())
+	})
+}
+
+func TestZapHandler_NetAddresses(t *testing.T) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"}), zapcore.AddSync(&buf), zapcore.InfoLevel)
+
+	NewLogger(core, nil).Info("hi",
+		"ipv4", net.ParseIP("192.168.0.1"),
+		"ipv6", net.ParseIP("2001:db8::1"),
+		"mac", net.HardwareAddr{0x00, 0x1a, 0x2b, 0x3c, 0x4d, 0x5e},
+	)
+	assert.JSONEq(t, `{"msg":"hi","ipv4":"192.168.0.1","ipv6":"2001:db8::1","mac":"00:1a:2b:3c:4d:5e"}`, buf.String())
+}
+
+// shimInfo is a logging shim.  Records logged through it have the shim's PC.
+//
+//go:noinline
+func shimInfo(l *slog.Logger, msg string) {
+	l.Info(msg)
+}
+
+func TestZapHandler_CallerSkip(t *testing.T) {
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+
+	// default reports the shim
+	shimInfo(NewLogger(mockCore, &ZapHandlerOptions{AddSource: true}), "hi")
+	require.NotNil(t, mockCore.lastEntry)
+	shimLine := mockCore.lastEntry.Caller.Line
+
+	l := NewLogger(mockCore, &ZapHandlerOptions{AddSource: true, CallerSkip: 1})
+	_, file, line, _ := runtime.Caller(0)
+	shimInfo(l, "hi") // the reported caller
+	line++
+
+	caller := mockCore.lastEntry.Caller
+	assert.True(t, caller.Defined)
+	assert.Equal(t, file, caller.File)
+	assert.Equal(t, line, caller.Line)
+	assert.NotEqual(t, shimLine, caller.Line)
+
+	// also applies to derived handlers
+	_, _, line, _ = runtime.Caller(0)
+	shimInfo(l.With("a", 1).WithGroup("g"), "hi")
+	assert.Equal(t, file, mockCore.lastEntry.Caller.File)
+	assert.Equal(t, line+1, mockCore.lastEntry.Caller.Line)
+}
+
+// parseLogfmt parses a line written by slog.TextHandler into a map.
+func parseLogfmt(t *testing.T, line string) map[string]any {
+	m := map[string]any{}
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimLeft(line, " ") {
+		key, rest, ok := strings.Cut(line, "=")
+		require.True(t, ok, line)
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			require.NoError(t, err)
+			val, err = strconv.Unquote(quoted)
+			require.NoError(t, err)
+			rest = rest[len(quoted):]
+		} else {
+			val, rest, _ = strings.Cut(rest, " ")
+		}
+		m[key] = val
+		line = rest
+	}
+	return m
+}
+
+func TestDefaultEncoderConfig(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	newRecord := func(pc uintptr) slog.Record {
+		r := slog.NewRecord(ts, slog.LevelWarn, "hello, world", pc)
+		r.AddAttrs(slog.String("user", "alice"), slog.Duration("took", 1500*time.Millisecond))
+		return r
+	}
+
+	var textBuf strings.Builder
+	require.NoError(t, slog.NewTextHandler(&textBuf, nil).Handle(context.Background(), newRecord(0)))
+
+	var zapBuf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(DefaultEncoderConfig()), zapcore.AddSync(&zapBuf), zapcore.DebugLevel)
+	require.NoError(t, NewZapHandler(core, nil).Handle(context.Background(), newRecord(0)))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(zapBuf.Bytes(), &got))
+	assert.Equal(t, parseLogfmt(t, textBuf.String()), got)
+	assert.Equal(t, "2024-01-02T03:04:05.678Z", got[slog.TimeKey])
+
+	t.Run("source", func(t *testing.T) {
+		pc, file, line, _ := runtime.Caller(0)
+
+		textBuf.Reset()
+		require.NoError(t, slog.NewTextHandler(&textBuf, &slog.HandlerOptions{AddSource: true}).Handle(context.Background(), newRecord(pc)))
+
+		zapBuf.Reset()
+		require.NoError(t, NewZapHandler(core, &ZapHandlerOptions{AddSource: true}).Handle(context.Background(), newRecord(pc)))
+
+		var got map[string]any
+		require.NoError(t, json.Unmarshal(zapBuf.Bytes(), &got))
+		assert.Equal(t, parseLogfmt(t, textBuf.String()), got)
+		assert.Equal(t, fmt.Sprintf("%s:%d", file, line), got[slog.SourceKey])
+	})
+}
+
+func TestZapHandler_SourcePrecedence(t *testing.T) {
+	pc, file, line, _ := runtime.Caller(0)
+	attrSrc := &slog.Source{Function: "wrapper.Func", File: "/src/wrapper/wrapper.go", Line: 42}
+
+	pcCaller := zapcore.EntryCaller{Defined: true, PC: pc, File: file, Line: line}
+	attrCaller := zapcore.EntryCaller{Defined: true, File: "/src/wrapper/wrapper.go", Line: 42}
+
+	tests := []struct {
+		name       string
+		precedence SourcePrecedence
+		pc         uintptr
+		src        any
+		wantCaller zapcore.EntryCaller
+		wantFields []zapcore.Field
+	}{
+		{
+			name:       "both",
+			precedence: SourcePrecedenceBoth,
+			pc:         pc,
+			src:        attrSrc,
+			wantCaller: pcCaller,
+			wantFields: []zapcore.Field{zap.Any(slog.SourceKey, attrSrc), zap.String("k", "v")},
+		},
+		{
+			name:       "pc",
+			precedence: SourcePrecedencePC,
+			pc:         pc,
+			src:        attrSrc,
+			wantCaller: pcCaller,
+			wantFields: []zapcore.Field{zap.String("k", "v")},
+		},
+		{
+			name:       "pc without pc",
+			precedence: SourcePrecedencePC,
+			src:        attrSrc,
+			wantCaller: attrCaller,
+			wantFields: []zapcore.Field{zap.String("k", "v")},
+		},
+		{
+			name:       "attr",
+			precedence: SourcePrecedenceAttr,
+			pc:         pc,
+			src:        attrSrc,
+			wantCaller: attrCaller,
+			wantFields: []zapcore.Field{zap.String("k", "v")},
+		},
+		{
+			name:       "attr with source value",
+			precedence: SourcePrecedenceAttr,
+			pc:         pc,
+			src:        *attrSrc,
+			wantCaller: attrCaller,
+			wantFields: []zapcore.Field{zap.String("k", "v")},
+		},
+		{
+			name:       "attr without attr",
+			precedence: SourcePrecedenceAttr,
+			pc:         pc,
+			wantCaller: pcCaller,
+			wantFields: []zapcore.Field{zap.String("k", "v")},
+		},
+		{
+			name:       "attr which isn't a source",
+			precedence: SourcePrecedenceAttr,
+			pc:         pc,
+			src:        "elsewhere",
+			wantCaller: pcCaller,
+			wantFields: []zapcore.Field{zap.String(slog.SourceKey, "elsewhere"), zap.String("k", "v")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+			h := NewZapHandler(mockCore, &ZapHandlerOptions{AddSource: true, SourcePrecedence: tt.precedence})
+
+			r := slog.NewRecord(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), slog.LevelInfo, "msg", tt.pc)
+			if tt.src != nil {
+				r.AddAttrs(slog.Any(slog.SourceKey, tt.src))
+			}
+			r.AddAttrs(slog.String("k", "v"))
+			require.NoError(t, h.Handle(context.Background(), r))
+
+			assert.Equal(t, tt.wantCaller, mockCore.lastEntry.Caller)
+			assert.Equal(t, tt.wantFields, mockCore.lastFields)
+		})
+	}
+
+	t.Run("nested source attr is just a field", func(t *testing.T) {
+		mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+		h := NewZapHandler(mockCore, &ZapHandlerOptions{AddSource: true, SourcePrecedence: SourcePrecedenceAttr}).WithGroup("g")
+
+		r := slog.NewRecord(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), slog.LevelInfo, "msg", pc)
+		r.AddAttrs(slog.Any(slog.SourceKey, attrSrc))
+		require.NoError(t, h.Handle(context.Background(), r))
+
+		assert.Equal(t, pcCaller, mockCore.lastEntry.Caller)
+		assert.Equal(t, []zapcore.Field{zap.Any("g", []zapcore.Field{zap.Any(slog.SourceKey, attrSrc)})}, mockCore.lastFields)
+	})
+}
+
+// loggerFilterCore only checks entries from one logger, but its Enabled only considers the level.
+type loggerFilterCore struct {
+	zapcore.Core
+	loggerName string
+}
+
+func (c *loggerFilterCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.LoggerName != c.loggerName {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+func TestZapHandler_StrictEnabled(t *testing.T) {
+	inner, logs := observer.New(zapcore.InfoLevel)
+	core := &loggerFilterCore{Core: inner, loggerName: "db"}
+
+	lax := NewZapHandler(core, &ZapHandlerOptions{LoggerNameKey: "logger"})
+	assert.True(t, lax.Enabled(context.Background(), slog.LevelInfo))
+
+	strict := NewZapHandler(core, &ZapHandlerOptions{LoggerNameKey: "logger", StrictEnabled: true})
+	assert.False(t, strict.Enabled(context.Background(), slog.LevelInfo))
+	db := strict.WithAttrs([]slog.Attr{slog.String("logger", "db")})
+	assert.False(t, db.Enabled(context.Background(), slog.LevelDebug))
+	assert.True(t, db.Enabled(context.Background(), slog.LevelInfo))
+
+	// Enabled doesn't write
+	assert.Zero(t, logs.Len())
+
+	slog.New(strict).Info("dropped")
+	slog.New(db).Info("written")
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "written", entries[0].Message)
+}
+
+func TestZapHandler_StrictEnabled_IncreaseLevelCore(t *testing.T) {
+	inner, logs := observer.New(zapcore.DebugLevel)
+	core, err := zapcore.NewIncreaseLevelCore(inner, zapcore.WarnLevel)
+	require.NoError(t, err)
+
+	h := NewZapHandler(core, &ZapHandlerOptions{StrictEnabled: true})
+	assert.False(t, h.Enabled(context.Background(), slog.LevelInfo))
+	assert.True(t, h.Enabled(context.Background(), slog.LevelWarn))
+	assert.Zero(t, logs.Len())
+
+	l := slog.New(h)
+	l.Info("dropped")
+	l.Warn("written")
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "written", entries[0].Message)
+}
+
+func TestZapHandler_StrictEnabled_Sampler(t *testing.T) {
+	newSampler := func() (zapcore.Core, *observer.ObservedLogs) {
+		inner, logs := observer.New(zapcore.InfoLevel)
+		return zapcore.NewSamplerWithOptions(inner, time.Hour, 1, 0), logs
+	}
+
+	// by default, Enabled doesn't touch the sampler, so each distinct message is logged once
+	core, logs := newSampler()
+	l := slog.New(NewZapHandler(core, nil))
+	l.Info("a")
+	l.Info("b")
+	l.Info("c")
+	assert.Len(t, logs.TakeAll(), 3)
+
+	// with StrictEnabled, each Enabled call is sampled as an entry with an empty message, which
+	// uses up the sampler's budget, as documented
+	core, logs = newSampler()
+	l = slog.New(NewZapHandler(core, &ZapHandlerOptions{StrictEnabled: true}))
+	l.Info("a")
+	l.Info("b")
+	l.Info("c")
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "a", entries[0].Message)
+}
+
+// level is a named primitive type.
+type level int
+
+func (l level) String() string {
+	return fmt.Sprintf("L%d", int(l))
+}
+
+func TestZapHandler_PointersToPrimitives(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	n := 42
+	s := "str"
+	lvl := level(3)
+	var nilTime *time.Time
+	var nilInt *int
+
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	NewLogger(mockCore, nil).Info("msg",
+		slog.Any("time", &ts),
+		slog.Any("int", &n),
+		slog.Any("string", &s),
+		slog.Any("named", &lvl),
+		slog.Any("niltime", nilTime),
+		slog.Any("nilint", nilInt),
+	)
+	assert.Equal(t, []zapcore.Field{
+		zap.Time("time", ts),
+		zap.Int("int", 42),
+		zap.String("string", "str"),
+		zap.Stringer("named", level(3)),
+		zap.Reflect("niltime", nil),
+		zap.Reflect("nilint", nil),
+	}, mockCore.lastFields)
+
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg", EncodeTime: zapcore.RFC3339TimeEncoder}), zapcore.AddSync(&buf), zapcore.InfoLevel)
+	NewLogger(core, nil).Info("msg", "time", &ts, "int", &n, "named", &lvl, "niltime", nilTime, "nilint", nilInt)
+	assert.JSONEq(t, `{"msg":"msg","time":"2024-01-02T03:04:05Z","int":42,"named":"L3","niltime":null,"nilint":null}`, buf.String())
+}
+
+func TestZapHandler_SourceAsField(t *testing.T) {
+	pc, file, line, _ := runtime.Caller(0)
+	function := runtime.FuncForPC(pc).Name()
+
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	h := NewZapHandler(mockCore, &ZapHandlerOptions{AddSource: true, SourceAsField: true, ShortSourceKey: "caller"})
+
+	r := slog.NewRecord(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), slog.LevelInfo, "msg", pc)
+	r.AddAttrs(slog.String("k", "v"))
+
+	sourceField := zap.Any(slog.SourceKey, []zapcore.Field{
+		zap.String("function", function),
+		zap.String("file", file),
+		zap.Int("line", line),
+	})
+
+	// nested in the open group
+	require.NoError(t, h.WithAttrs([]slog.Attr{slog.String("a", "b")}).WithGroup("req").Handle(context.Background(), r))
+	assert.False(t, mockCore.lastEntry.Caller.Defined)
+	assert.Equal(t, []zapcore.Field{
+		zap.String("a", "b"),
+		zap.Any("req", []zapcore.Field{zap.String("k", "v"), sourceField}),
+	}, mockCore.lastFields)
+
+	// top-level without groups, even if the record has no attrs
+	require.NoError(t, h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", pc)))
+	assert.Equal(t, []zapcore.Field{sourceField}, mockCore.lastFields)
+
+	// the source field doesn't count for RequireFields
+	mockCore.lastEntry = nil
+	h2 := NewZapHandler(mockCore, &ZapHandlerOptions{AddSource: true, SourceAsField: true, RequireFields: true})
+	require.NoError(t, h2.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", pc)))
+	assert.Nil(t, mockCore.lastEntry)
+
+	// no source, no field
+	require.NoError(t, h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)))
+	assert.Empty(t, mockCore.lastFields)
+}
+
+type syncRecordingCore struct {
+	*mockCoreRecorder
+	syncs   int
+	syncErr error
+}
+
+func (c *syncRecordingCore) Sync() error {
+	c.syncs++
+	return c.syncErr
+}
+
+func TestZapHandler_Sync(t *testing.T) {
+	core := &syncRecordingCore{mockCoreRecorder: &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}}
+	h := NewZapHandler(core, nil)
+
+	require.NoError(t, h.Sync())
+	assert.Equal(t, 1, core.syncs)
+
+	// derived handlers sync the same core
+	require.NoError(t, h.WithAttrs([]slog.Attr{slog.String("a", "b")}).(*ZapHandler).Close())
+	assert.Equal(t, 2, core.syncs)
+
+	core.syncErr = errors.New("boom")
+	assert.EqualError(t, h.Sync(), "boom")
+
+	var _ io.Closer = h
+}
+
+func TestZapHandler_SyncFlushesCoalescer(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := NewZapHandler(core, &ZapHandlerOptions{CoalesceWindow: time.Hour})
+	l := slog.New(h)
+	for i := 0; i < 3; i++ {
+		l.Info("hello")
+	}
+	require.Len(t, logs.All(), 1)
+
+	require.NoError(t, h.Sync())
+	entries := logs.TakeAll()
+	require.Len(t, entries, 2)
+	assert.Equal(t, []zapcore.Field{zap.Int(RepeatedKey, 2)}, entries[1].Context)
+}
+
+type color int
+
+func (c color) String() string {
+	return [...]string{"red", "green"}[c]
+}
+
+type stringerError struct{}
+
+func (stringerError) Error() string  { return "error text" }
+func (stringerError) String() string { return "stringer text" }
+
+func TestZapHandler_Stringer(t *testing.T) {
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	h := NewZapHandler(mockCore, nil)
+
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+	r.AddAttrs(slog.Any("color", color(1)), slog.Any("err", stringerError{}))
+	require.NoError(t, h.Handle(context.Background(), r))
+
+	// same as native zap
+	assert.Equal(t, []zapcore.Field{
+		zap.Stringer("color", color(1)),
+		zap.Any("err", stringerError{}),
+	}, mockCore.lastFields)
+	assert.Equal(t, zapcore.StringerType, mockCore.lastFields[0].Type)
+	// errors which are also Stringers are still errors
+	assert.Equal(t, zapcore.ErrorType, mockCore.lastFields[1].Type)
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range mockCore.lastFields {
+		f.AddTo(enc)
+	}
+	assert.Equal(t, map[string]any{"color": "green", "err": "error text"}, enc.Fields)
+}
+
+func TestZapHandler_SkipOnContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+
+	h := NewZapHandler(core, &ZapHandlerOptions{SkipOnContextDone: true})
+	assert.ErrorIs(t, h.Handle(ctx, r), context.Canceled)
+	assert.Zero(t, logs.Len())
+
+	require.NoError(t, h.Handle(context.Background(), r))
+	assert.Equal(t, 1, logs.Len())
+
+	// disabled by default
+	require.NoError(t, NewZapHandler(core, nil).Handle(ctx, r))
+	assert.Equal(t, 2, logs.Len())
+}
+
+func TestAttrsToFields(t *testing.T) {
+	fields := AttrsToFields([]slog.Attr{
+		slog.String("user", "alice"),
+		slog.Group("req", slog.Int("status", 200), slog.Group("headers", slog.String("accept", "json"))),
+		slog.Any("valuer", logValuerFunc(func() slog.Value { return slog.StringValue("resolved") })),
+		slog.Any("groupValuer", logValuerFunc(func() slog.Value { return slog.GroupValue(slog.Bool("ok", true)) })),
+		slog.Group("", slog.String("inlined", "x")),
+		{},
+		slog.Group("empty"),
+	})
+	assert.Equal(t, []zapcore.Field{
+		zap.String("user", "alice"),
+		zap.Any("req", []zapcore.Field{
+			zap.Int64("status", 200),
+			zap.Any("headers", []zapcore.Field{zap.String("accept", "json")}),
+		}),
+		zap.String("valuer", "resolved"),
+		zap.Any("groupValuer", []zapcore.Field{zap.Bool("ok", true)}),
+		zap.Inline(zap.Dict("", zap.String("inlined", "x")).Interface.(zapcore.ObjectMarshaler)),
+	}, fields)
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	assert.Equal(t, map[string]any{
+		"user":        "alice",
+		"req":         map[string]any{"status": int64(200), "headers": map[string]any{"accept": "json"}},
+		"valuer":      "resolved",
+		"groupValuer": map[string]any{"ok": true},
+		"inlined":     "x",
+	}, enc.Fields)
+}
+
+func TestZapHandler_MessageKey(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := NewLogger(core, &ZapHandlerOptions{MessageKey: "message", MessageHashKey: "hash"})
+
+	l.Info("original", "message", "from attr", "user", "alice")
+	// only ungrouped string attrs match
+	l.Info("original", "message", 5)
+	l.WithGroup("g").Info("original", "message", "grouped")
+	// disabled by default
+	NewLogger(core, nil).Info("original", "message", "from attr")
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 4)
+	assert.Equal(t, "from attr", entries[0].Message)
+	assert.Equal(t, []zapcore.Field{zap.String("user", "alice"), zap.String("hash", MessageHash("from attr"))}, entries[0].Context)
+	assert.Equal(t, "original", entries[1].Message)
+	assert.Equal(t, int64(5), entries[1].ContextMap()["message"])
+	assert.Equal(t, "original", entries[2].Message)
+	assert.Equal(t, "original", entries[3].Message)
+	assert.Equal(t, "from attr", entries[3].ContextMap()["message"])
+}
+
+func TestZapHandler_WithEmptyGroup(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := NewZapHandler(core, nil)
+	assert.Same(t, h, h.WithGroup(""))
+
+	slog.New(h).WithGroup("").With("a", 1).WithGroup("").Info("msg", "b", 2)
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	assert.Equal(t, []zapcore.Field{zap.Int64("a", 1), zap.Int64("b", 2)}, entries[0].Context)
+}
+
+func TestZapHandler_DropKeyPrefixes(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	var replaced []string
+	l := NewLogger(core, &ZapHandlerOptions{
+		DropKeyPrefixes: []string{"debug_", "req.internal.", "secrets"},
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			replaced = append(replaced, a.Key)
+			return a
+		},
+	})
+
+	l.With("debug_id", 1, "service", "api").
+		WithGroup("req").
+		Info("msg",
+			"debug_flag", true, // only top-level keys start with "debug_"
+			slog.Group("internal", "id", 2, "trace", "t"),
+			slog.Group("public", "id", 3),
+		)
+	l.Info("msg", slog.Group("secrets", "password", "p"), "secretsmanager", "x", "user", "alice")
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 2)
+	assert.Equal(t, map[string]any{
+		"service": "api",
+		"req": map[string]any{
+			"debug_flag": true,
+			"public":     map[string]any{"id": int64(3)},
+		},
+	}, entries[0].ContextMap())
+	assert.Equal(t, map[string]any{"user": "alice"}, entries[1].ContextMap())
+
+	// dropped before ReplaceAttr
+	assert.NotContains(t, replaced, "debug_id")
+	assert.NotContains(t, replaced, "trace")
+	assert.NotContains(t, replaced, "password")
+}
+
+func TestHasKeyPrefix(t *testing.T) {
+	tests := []struct {
+		groups []string
+		key    string
+		prefix string
+		want   bool
+	}{
+		{nil, "internal_id", "internal", true},
+		{nil, "id", "internal", false},
+		{[]string{"req"}, "id", "req.", true},
+		{[]string{"req"}, "id", "re", true},
+		{[]string{"req"}, "id", "req.i", true},
+		{[]string{"req"}, "id", "req.x", false},
+		{[]string{"req"}, "id", "reqx", false},
+		{[]string{"req", "internal"}, "id", "req.internal.id", true},
+		{[]string{"req", "internal"}, "id", "req.internal.ids", false},
+		{[]string{"request"}, "id", "req.", false},
+		{nil, "id", "", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, hasKeyPrefix(tt.groups, tt.key, tt.prefix), "%v %q %q", tt.groups, tt.key, tt.prefix)
+	}
+}
+
+func TestZapHandler_AnyOfSlogValue(t *testing.T) {
+	// slog.Any unwraps slog.Values, so they're converted by kind, not as opaque values
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+	h := NewZapHandler(mockCore, nil)
+
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+	r.AddAttrs(
+		slog.Any("group", slog.GroupValue(slog.String("a", "b"), slog.Any("inner", slog.IntValue(1)))),
+		slog.Any("int", slog.IntValue(2)),
+		slog.Any("empty", slog.GroupValue()),
+	)
+	require.Equal(t, slog.KindGroup, slog.Any("group", slog.GroupValue()).Value.Kind())
+	require.NoError(t, h.Handle(context.Background(), r))
+
+	assert.Equal(t, []zapcore.Field{
+		zap.Any("group", []zapcore.Field{zap.String("a", "b"), zap.Int64("inner", 1)}),
+		zap.Int64("int", 2),
+	}, mockCore.lastFields)
+}
+
+func TestZapHandler_LinearLevels(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  zapcore.Level
+	}{
+		{slog.Level(math.MinInt), zapcore.Level(math.MinInt8)},
+		{slog.Level(-12), zapcore.Level(-3)},
+		{slog.Level(-8), zapcore.Level(-2)},
+		{slog.Level(-5), zapcore.DebugLevel},
+		{slog.LevelDebug, zapcore.DebugLevel},
+		{slog.Level(-3), zapcore.InfoLevel},
+		{slog.LevelInfo, zapcore.InfoLevel},
+		{slog.Level(2), zapcore.WarnLevel},
+		{slog.LevelWarn, zapcore.WarnLevel},
+		{slog.LevelWarn + 1, zapcore.ErrorLevel},
+		{slog.LevelError, zapcore.ErrorLevel},
+		{slog.LevelError + 1, zapcore.DPanicLevel},
+		{slog.LevelError + 4, zapcore.DPanicLevel},
+		{slog.Level(16), zapcore.PanicLevel},
+		{slog.Level(20), zapcore.FatalLevel},
+		{slog.Level(40), zapcore.Level(10)},
+		{slog.Level(math.MaxInt), zapcore.Level(math.MaxInt8)},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, linearSlogToZapLvl(tt.level), "slog level %d", int(tt.level))
+	}
+
+	core, logs := observer.New(zapcore.Level(-2))
+	l := NewLogger(core, &ZapHandlerOptions{LinearLevels: true})
+	assert.False(t, l.Enabled(context.Background(), slog.Level(-12)))
+	assert.True(t, l.Enabled(context.Background(), slog.Level(-8)))
+	l.Log(context.Background(), slog.Level(-8), "trace")
+	l.Log(context.Background(), slog.LevelError+4, "critical")
+	entries := logs.TakeAll()
+	require.Len(t, entries, 2)
+	assert.Equal(t, zapcore.Level(-2), entries[0].Level)
+	assert.Equal(t, zapcore.DPanicLevel, entries[1].Level)
+
+	// clamped by default
+	NewLogger(core, nil).Log(context.Background(), slog.LevelError+4, "critical")
+	assert.Equal(t, zapcore.ErrorLevel, logs.TakeAll()[0].Level)
+}
+
+func TestZapHandler_DedupeKeys(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := NewLogger(core, &ZapHandlerOptions{DedupeKeys: true})
+
+	// same scope: last wins
+	l.With("id", 1, "service", "api").Info("msg", "id", 2)
+	// same name in different groups is kept
+	l.With("id", 1).WithGroup("req").With("id", 2, "id", 3).Info("msg", slog.Group("user", "id", 4, "id", 5), "id", 6)
+	// no duplicates
+	l.With("a", 1).Info("msg", "b", 2)
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3)
+	assert.Equal(t, []zapcore.Field{zap.String("service", "api"), zap.Int64("id", 2)}, entries[0].Context)
+	assert.Equal(t, []zapcore.Field{
+		zap.Int64("id", 1),
+		zap.Any("req", []zapcore.Field{
+			zap.Any("user", []zapcore.Field{zap.Int64("id", 5)}),
+			zap.Int64("id", 6),
+		}),
+	}, entries[1].Context)
+	assert.Equal(t, []zapcore.Field{zap.Int64("a", 1), zap.Int64("b", 2)}, entries[2].Context)
+
+	// handler fields aren't modified
+	h := NewZapHandler(core, &ZapHandlerOptions{DedupeKeys: true}).
+		WithAttrs([]slog.Attr{slog.Group("g", "k", 1, "k", 2)})
+	slog.New(h).Info("msg")
+	assert.Equal(t, []zapcore.Field{zap.Any("g", []zapcore.Field{zap.Int64("k", 2)})}, logs.TakeAll()[0].Context)
+	assert.Equal(t, []zapcore.Field{zap.Any("g", []zapcore.Field{zap.Int64("k", 1), zap.Int64("k", 2)})}, h.(*ZapHandler).Fields())
+
+	// disabled by default
+	NewLogger(core, nil).With("id", 1).Info("msg", "id", 2)
+	assert.Len(t, logs.TakeAll()[0].Context, 2)
+
+	// fields added after the record's attributes are deduped too
+	l = NewLogger(core, &ZapHandlerOptions{
+		DedupeKeys:     true,
+		MessageHashKey: "hash",
+		ContextAttrs: func(context.Context) []slog.Attr {
+			return []slog.Attr{slog.String("trace", "ctx")}
+		},
+	})
+	l.InfoContext(context.Background(), "msg", "trace", "record", "hash", "record")
+	assert.Equal(t, []zapcore.Field{
+		zap.String("trace", "ctx"),
+		zap.String("hash", MessageHash("msg")),
+	}, logs.TakeAll()[0].Context)
+}
+
+// selfValuer returns itself from LogValue, forever.
+type selfValuer struct{}
+
+func (v selfValuer) LogValue() slog.Value { return slog.AnyValue(v) }
+
+func TestZapHandler_LogValuerChains(t *testing.T) {
+	chain := logValuerFunc(func() slog.Value {
+		return slog.AnyValue(logValuerFunc(func() slog.Value { return slog.StringValue("resolved") }))
+	})
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := NewLogger(core, &ZapHandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == "replaced" {
+				// ReplaceAttr may return a chain too
+				return slog.Any(a.Key, chain)
+			}
+			return a
+		},
+	})
+	l.Info("msg", "chain", chain, "replaced", "x", "self", selfValuer{})
+
+	ctx := logs.TakeAll()[0].ContextMap()
+	assert.Equal(t, "resolved", ctx["chain"])
+	assert.Equal(t, "resolved", ctx["replaced"])
+	// slog.Value.Resolve bounds the chain, and returns an error value instead
+	assert.Contains(t, ctx["self"], "LogValue called too many times")
+}
+
+func TestZapHandler_TimePrecision(t *testing.T) {
+	// the record's time reaches the entry unchanged.  Attr times only have their monotonic clock
+	// reading stripped, which doesn't change their precision.
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	now := time.Now()
+	mockCore := &mockCoreRecorder{mockCore: &mockCore{enabledLevel: zapcore.InfoLevel}}
+
+	for _, tm := range []time.Time{ts, now} {
+		r := slog.NewRecord(tm, slog.LevelInfo, "msg", 0)
+		r.AddAttrs(slog.Time("at", tm))
+		require.NoError(t, NewZapHandler(mockCore, nil).Handle(context.Background(), r))
+
+		assert.Equal(t, tm, mockCore.lastEntry.Time)
+		assert.Equal(t, tm.Nanosecond(), mockCore.lastEntry.Time.Nanosecond())
+		at := time.Unix(0, mockCore.lastFields[0].Integer).In(mockCore.lastFields[0].Interface.(*time.Location))
+		assert.True(t, tm.Equal(at))
+		assert.Equal(t, tm.Nanosecond(), at.Nanosecond())
+	}
+}
+
+type traceIDKey struct{}
+
+func TestZapHandler_ContextAttrs(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := NewLogger(core, &ZapHandlerOptions{
+		ContextAttrs: func(ctx context.Context) []slog.Attr {
+			if id, ok := ctx.Value(traceIDKey{}).(string); ok {
+				return []slog.Attr{slog.String("trace_id", id)}
+			}
+			return nil
+		},
+	})
+
+	ctx := context.WithValue(context.Background(), traceIDKey{}, "abc123")
+	l.With("service", "api").WithGroup("req").InfoContext(ctx, "msg", "id", 1)
+	l.InfoContext(ctx, "no attrs")
+	l.Info("no trace")
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3)
+	assert.Equal(t, []zapcore.Field{
+		zap.String("service", "api"),
+		zap.Any("req", []zapcore.Field{zap.Int64("id", 1)}),
+		zap.String("trace_id", "abc123"),
+	}, entries[0].Context)
+	assert.Equal(t, []zapcore.Field{zap.String("trace_id", "abc123")}, entries[1].Context)
+	assert.Empty(t, entries[2].Context)
+}
+
+func TestNewZapHandler_NilCore(t *testing.T) {
+	h := NewZapHandler(nil, &ZapHandlerOptions{AddSource: true})
+	assert.NotPanics(t, func() {
+		l := slog.New(h)
+		assert.False(t, l.Enabled(context.Background(), slog.LevelError))
+		l.With("a", 1).WithGroup("g").Error("msg", "b", 2)
+		require.NoError(t, h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelError, "msg", 0)))
+		require.NoError(t, h.Sync())
+	})
+}
+
+func TestZapHandler_MaxGroupDepth(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	nested := slog.Group("a", "x", 1, slog.Group("b", slog.Group("c", "d", 2), slog.Group("", slog.Group("e", "f", 3))))
+	NewLogger(core, &ZapHandlerOptions{MaxGroupDepth: 2}).Info("msg", nested)
+	// the handler's groups count too
+	NewLogger(core, &ZapHandlerOptions{MaxGroupDepth: 2}).WithGroup("req").Info("msg", nested)
+	// no limit
+	NewLogger(core, &ZapHandlerOptions{MaxGroupDepth: -1}).Info("msg", nested)
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 3)
+	assert.Equal(t, map[string]any{
+		"a": map[string]any{"x": int64(1), "b": map[string]any{"c.d": int64(2), "e.f": int64(3)}},
+	}, entries[0].ContextMap())
+	assert.Equal(t, map[string]any{
+		"req": map[string]any{"a": map[string]any{"x": int64(1), "b.c.d": int64(2), "b.e.f": int64(3)}},
+	}, entries[1].ContextMap())
+	assert.Equal(t, map[string]any{
+		"a": map[string]any{"x": int64(1), "b": map[string]any{"c": map[string]any{"d": int64(2)}, "e": map[string]any{"f": int64(3)}}},
+	}, entries[2].ContextMap())
+
+	// deep groups are flattened at DefaultMaxGroupDepth
+	deepGroup := func(depth int) slog.Attr {
+		deep := slog.Int("leaf", 1)
+		for i := depth - 1; i >= 0; i-- {
+			deep = slog.Group("g"+strconv.Itoa(i), deep)
+		}
+		return deep
+	}
+	flattened := func(depth int) (string, any) {
+		NewLogger(core, nil).Info("msg", deepGroup(depth))
+		m := logs.TakeAll()[0].ContextMap()
+		for i := 0; i < DefaultMaxGroupDepth; i++ {
+			require.Len(t, m, 1)
+			m = m["g"+strconv.Itoa(i)].(map[string]any)
+		}
+		require.Len(t, m, 1)
+		for k, v := range m {
+			return k, v
+		}
+		return "", nil
+	}
+
+	k, v := flattened(1000)
+	assert.True(t, strings.HasPrefix(k, "g64.g65."))
+	assert.True(t, strings.HasSuffix(k, ".g999.leaf"))
+	assert.Equal(t, int64(1), v)
+
+	// pathologically deep groups are cut off
+	k, v = flattened(100000)
+	assert.True(t, strings.HasPrefix(k, "g64.g65."))
+	assert.True(t, strings.HasSuffix(k, ".g1087.g1088"))
+	assert.Equal(t, "!group exceeded max depth (1024)", v)
+}
+
+// selfGroupValuer resolves to a group which contains itself.
+type selfGroupValuer struct{}
+
+func (v selfGroupValuer) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("n", 1), slog.Any("self", v))
+}
+
+func TestZapHandler_MaxGroupDepth_SelfReferencingLogValuer(t *testing.T) {
+	for _, max := range []int{0, 3} {
+		t.Run(strconv.Itoa(max), func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			NewLogger(core, &ZapHandlerOptions{MaxGroupDepth: max}).Info("msg", "v", selfGroupValuer{})
+
+			// the group is flattened at MaxGroupDepth, then cut off
+			depth := max
+			if depth == 0 {
+				depth = DefaultMaxGroupDepth
+			}
+			m := logs.TakeAll()[0].ContextMap()["v"].(map[string]any)
+			for i := 1; i < depth; i++ {
+				require.Len(t, m, 2)
+				m = m["self"].(map[string]any)
+			}
+			// n, then self.n, self.self.n, and so on, then the cutoff
+			require.Len(t, m, maxFlattenedDepth+2)
+			require.Equal(t, int64(1), m[strings.Repeat("self.", maxFlattenedDepth)+"n"])
+			require.Equal(t, "!group exceeded max depth (1024)", m[strings.Repeat("self.", maxFlattenedDepth)+"self"])
+		})
+	}
+}
+
+func TestZapHandler_FlattenGroupTruncates(t *testing.T) {
+	attrs := make([]slog.Attr, maxFlattenedAttrs+10)
+	for i := range attrs {
+		attrs[i] = slog.Int("k"+strconv.Itoa(i), i)
+	}
+	fields := NewZapHandler(zapcore.NewNopCore(), nil).flattenGroup(nil, slog.Attr{Key: "g", Value: slog.GroupValue(attrs...)})
+	require.Len(t, fields, maxFlattenedAttrs)
+	assert.Equal(t, zap.Int64("g.k0", 0), fields[0])
+	assert.Equal(t, zap.String("g.k"+strconv.Itoa(maxFlattenedAttrs-1), "!group truncated after 65536 attributes"), fields[len(fields)-1])
+}
+
+func TestSlogToZapLevel(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  zapcore.Level
+	}{
+		{slog.LevelDebug - 4, zapcore.DebugLevel},
+		{slog.LevelDebug, zapcore.DebugLevel},
+		{slog.LevelDebug + 1, zapcore.InfoLevel},
+		{slog.LevelInfo, zapcore.InfoLevel},
+		{slog.LevelInfo + 1, zapcore.WarnLevel},
+		{slog.LevelWarn, zapcore.WarnLevel},
+		{slog.LevelWarn + 1, zapcore.ErrorLevel},
+		{slog.LevelError, zapcore.ErrorLevel},
+		{slog.LevelError + 4, zapcore.ErrorLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			assert.Equal(t, tt.want, SlogToZapLevel(tt.level))
 		})
 	}
 }